@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"ermete/internal/config"
+	"ermete/internal/export"
+	"ermete/internal/storage"
+)
+
+// runCommand runs an offline subcommand against DATA_DIR and returns the
+// process exit code. Subcommands read the same environment as the server.
+func runCommand(name string, args []string) int {
+	var err error
+	switch name {
+	case "export":
+		err = runExport(args)
+	case "timelapse":
+		err = runTimelapse(args)
+	case "rotate-keys":
+		err = runRotateKeys(args)
+	case "verify":
+		err = runVerify(args)
+	case "help", "-h", "--help":
+		fmt.Fprintln(os.Stderr, "usage: ermete [export|timelapse|rotate-keys|verify] [flags]\nwithout a subcommand ermete starts the server")
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", name)
+		return 2
+	}
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ermete %s: %v\n", name, err)
+		return 1
+	}
+	return 0
+}
+
+// openReadOnlyStore opens the frame index without writing to it, so the
+// commands are safe to run next to a live server. The returned config has
+// DataDir resolved.
+func openReadOnlyStore(dataDir string) (*storage.FrameStore, config.Config, error) {
+	cfg, err := config.LoadOffline()
+	if err != nil {
+		return nil, cfg, err
+	}
+	if dataDir != "" {
+		cfg.DataDir = dataDir
+	}
+	backend, err := newBackend(cfg)
+	if err != nil {
+		return nil, cfg, err
+	}
+	keys, err := newKeyring(cfg)
+	if err != nil {
+		return nil, cfg, err
+	}
+	store, err := storage.OpenFrameStore(storage.Options{DataDir: cfg.DataDir, Layout: cfg.StorageLayout, Backend: backend, MaxImagePixels: cfg.MaxImagePixels, Keyring: keys, ReadOnly: true})
+	return store, cfg, err
+}
+
+// openStore opens the store for writing, for the maintenance commands that
+// must not run next to a live server. The idempotency keys are kept as the
+// server left them, whatever IDEMPOTENCY_TTL the command sees.
+func openStore(dataDir string) (*storage.FrameStore, error) {
+	cfg, err := config.LoadOffline()
+	if err != nil {
+		return nil, err
+	}
+	if dataDir != "" {
+		cfg.DataDir = dataDir
+	}
+	backend, err := newBackend(cfg)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := newKeyring(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return storage.OpenFrameStore(storage.Options{DataDir: cfg.DataDir, IdempotencyTTL: cfg.IdempotencyTTL, IdempotencyMax: cfg.IdempotencyMax, Layout: cfg.StorageLayout, Backend: backend, MaxImagePixels: cfg.MaxImagePixels, Keyring: keys, TimestampPolicy: cfg.TimestampPolicy, Maintenance: true})
+}
+
+// queryFlags registers the frame selection flags shared by the commands.
+func queryFlags(fs *flag.FlagSet) func() (storage.FrameQuery, error) {
+	since := fs.String("since", "", "only frames at or after this RFC3339 time")
+	until := fs.String("until", "", "only frames at or before this RFC3339 time")
+	timeField := fs.String("time-field", string(storage.TimeFieldTimestamp), "time that -since, -until and the frame order use: timestamp (capture) or received")
+	prefix := fs.String("frame-id-prefix", "", "only frames whose frame_id has this prefix")
+	sessionID := fs.String("session", "", "only frames of this session ID")
+	return func() (storage.FrameQuery, error) {
+		q := storage.FrameQuery{TimeField: storage.TimeField(*timeField), FrameIDPrefix: *prefix, SessionID: *sessionID}
+		if q.TimeField != storage.TimeFieldTimestamp && q.TimeField != storage.TimeFieldReceived {
+			return q, fmt.Errorf("invalid -time-field: %s", *timeField)
+		}
+		var err error
+		if q.Since, err = parseTimeFlag(*since); err != nil {
+			return q, fmt.Errorf("invalid -since: %w", err)
+		}
+		if q.Until, err = parseTimeFlag(*until); err != nil {
+			return q, fmt.Errorf("invalid -until: %w", err)
+		}
+		return q, nil
+	}
+}
+
+func parseTimeFlag(raw string) (time.Time, error) {
+	if raw == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339Nano, raw)
+}
+
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	out := fs.String("o", "", "output zip file (default stdout)")
+	dataDir := fs.String("data-dir", "", "data directory (default DATA_DIR)")
+	query := queryFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	q, err := query()
+	if err != nil {
+		return err
+	}
+	store, _, err := openReadOnlyStore(*dataDir)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	return writeOutput(*out, func(w io.Writer) error {
+		manifest, err := export.Zip(w, store, q)
+		if err == nil {
+			fmt.Fprintf(os.Stderr, "exported %d frames\n", manifest.Count)
+		}
+		return err
+	})
+}
+
+func runTimelapse(args []string) error {
+	fs := flag.NewFlagSet("timelapse", flag.ContinueOnError)
+	out := fs.String("o", "", "output AVI file (default stdout)")
+	dataDir := fs.String("data-dir", "", "data directory (default DATA_DIR)")
+	fps := fs.Int("fps", export.DefaultTimelapseFPS, "frames per second")
+	quality := fs.Int("quality", export.DefaultTimelapseQuality, "JPEG quality of transcoded frames (1-100)")
+	overlay := fs.Bool("overlay", false, "stamp the capture timestamp on every frame")
+	query := queryFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *fps < 1 || *fps > export.MaxTimelapseFPS {
+		return fmt.Errorf("-fps must be between 1 and %d", export.MaxTimelapseFPS)
+	}
+	if *quality < 1 || *quality > 100 {
+		return errors.New("-quality must be between 1 and 100")
+	}
+	q, err := query()
+	if err != nil {
+		return err
+	}
+	store, cfg, err := openReadOnlyStore(*dataDir)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	return writeOutput(*out, func(w io.Writer) error {
+		res, err := export.Timelapse(w, store, q, export.TimelapseOptions{FPS: *fps, Quality: *quality, Overlay: *overlay, TempDir: export.TimelapseTempDir(cfg.DataDir)})
+		if err == nil {
+			fmt.Fprintf(os.Stderr, "wrote %d frames (%dx%d), skipped %d\n", res.Frames, res.Width, res.Height, res.Skipped)
+		}
+		return err
+	})
+}
+
+// runVerify checks every stored frame against its recorded SHA-256 and
+// exits non-zero if anything is missing, corrupted or orphaned. Without
+// -quarantine the store is opened read-only and the server may keep running.
+func runVerify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	dataDir := fs.String("data-dir", "", "data directory (default DATA_DIR)")
+	quarantine := fs.Bool("quarantine", false, "move corrupted and orphaned files under frames/.quarantine (stop the server first)")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	var store *storage.FrameStore
+	var err error
+	if *quarantine {
+		store, err = openStore(*dataDir)
+	} else {
+		store, _, err = openReadOnlyStore(*dataDir)
+	}
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	rep, err := store.Scrub(*quarantine)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(rep); err != nil {
+			return err
+		}
+	} else {
+		for _, issue := range rep.Issues {
+			line := issue.Problem + "\t" + issue.FileName
+			if issue.Detail != "" {
+				line += "\t" + issue.Detail
+			}
+			if issue.Quarantined {
+				line += "\tquarantined"
+			}
+			fmt.Println(line)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "checked %d frames: %d missing, %d corrupted, %d orphaned, %d unverified, %d quarantined\n", rep.Checked, rep.Missing, rep.Corrupted, rep.Orphaned, rep.Unverified, rep.Quarantined)
+	if n := len(rep.Issues); n > 0 {
+		return fmt.Errorf("%d problems found", n)
+	}
+	return nil
+}
+
+// runRotateKeys re-encrypts every frame not sealed with the active key. It
+// writes the index, so the server must be stopped while it runs.
+func runRotateKeys(args []string) error {
+	fs := flag.NewFlagSet("rotate-keys", flag.ContinueOnError)
+	dataDir := fs.String("data-dir", "", "data directory (default DATA_DIR)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	cfg, err := config.LoadOffline()
+	if err != nil {
+		return err
+	}
+	if !cfg.EncryptionEnabled() {
+		return errors.New("ENCRYPTION_KEY_FILE or ENCRYPTION_KEYS is required")
+	}
+	store, err := openStore(*dataDir)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	res, err := store.RotateKeys()
+	fmt.Fprintf(os.Stderr, "re-encrypted %d frames, %d already current, %d missing\n", res.Rotated, res.Current, res.Missing)
+	return err
+}
+
+// writeOutput runs write against stdout, or against a temporary file renamed
+// to path once complete so a failed run never leaves a partial file behind.
+func writeOutput(path string, write func(io.Writer) error) error {
+	if path == "" || path == "-" {
+		bw := bufio.NewWriter(os.Stdout)
+		if err := write(bw); err != nil {
+			return err
+		}
+		return bw.Flush()
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".ermete-export-*")
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(tmp)
+	err = write(bw)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+	}
+	return err
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"ermete/internal/storage"
+)
+
+func TestMaintenanceCommandKeepsIdempotencyKeys(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("IDEMPOTENCY_TTL", "24h")
+	store, err := storage.OpenFrameStore(storage.Options{DataDir: dir, IdempotencyTTL: 24 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var img bytes.Buffer
+	if err := png.Encode(&img, image.NewGray(image.Rect(0, 0, 2, 2))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.SaveFrame(storage.FrameInput{IdempotencyKey: "k1", ContentType: "image/png"}, &img); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// an hour old key: live for the server, past the default TTL
+	journalPath := filepath.Join(dir, "idempotency.journal")
+	data, err := os.ReadFile(journalPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rec map[string]any
+	if err := json.Unmarshal(bytes.TrimSpace(data), &rec); err != nil {
+		t.Fatal(err)
+	}
+	rec["seen_at"] = time.Now().UTC().Add(-time.Hour)
+	line, _ := json.Marshal(rec)
+	if err := os.WriteFile(journalPath, append(line, '\n'), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if code := runCommand("verify", []string{"-data-dir", dir, "-quarantine"}); code != 0 {
+		t.Fatalf("verify exited with %d", code)
+	}
+	t.Setenv("IDEMPOTENCY_TTL", "")
+	if code := runCommand("verify", []string{"-data-dir", dir, "-quarantine"}); code != 0 {
+		t.Fatalf("verify with the default TTL exited with %d", code)
+	}
+	after, err := os.ReadFile(journalPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(after), `"k1"`) {
+		t.Fatalf("idempotency journal lost the key: %s", after)
+	}
+
+	reopened, err := storage.OpenFrameStore(storage.Options{DataDir: dir, IdempotencyTTL: 24 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if n := reopened.IdempotencySize(); n != 1 {
+		t.Fatalf("expected the key to survive the maintenance commands, got %d keys", n)
+	}
+}
@@ -14,6 +14,7 @@ import (
 	"ermete/internal/observability"
 	"ermete/internal/session"
 	"ermete/internal/storage"
+	"ermete/internal/webhook"
 	wrtc "ermete/internal/webrtc"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -21,6 +22,9 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1], os.Args[2:]))
+	}
 	cfg, err := config.Load()
 	if err != nil {
 		panic(err)
@@ -32,16 +36,62 @@ func main() {
 	defer logger.Sync()
 
 	metrics := observability.NewMetrics(prometheus.DefaultRegisterer)
-	store, err := storage.NewFrameStore(cfg.DataDir, cfg.IdempotencyTTL, cfg.IdempotencyMax, metrics)
+	backend, err := newBackend(cfg)
+	if err != nil {
+		logger.Fatal("failed to init storage backend", zap.Error(err))
+	}
+	keys, err := newKeyring(cfg)
+	if err != nil {
+		logger.Fatal("failed to load encryption keys", zap.Error(err))
+	}
+	store, err := storage.OpenFrameStore(storage.Options{DataDir: cfg.DataDir, IdempotencyTTL: cfg.IdempotencyTTL, IdempotencyMax: cfg.IdempotencyMax, Layout: cfg.StorageLayout, Backend: backend, Metrics: metrics, MaxImagePixels: cfg.MaxImagePixels, Keyring: keys, TimestampPolicy: cfg.TimestampPolicy})
 	if err != nil {
 		logger.Fatal("failed to init storage", zap.Error(err))
 	}
+	defer store.Close()
+	if cfg.StripMetadata {
+		store.Use(storage.NewMetadataStripper())
+	}
+	if cfg.PHashMode != config.PHashModeOff {
+		store.Use(storage.NewPHasher(cfg.PHashThreshold, cfg.PHashMode == config.PHashModeSkip))
+	}
+	if cfg.MotionThreshold > 0 {
+		store.Use(storage.NewMotionDetector(cfg.MotionThreshold))
+	}
+	if cfg.ThumbnailMaxDim > 0 {
+		store.Use(storage.NewThumbnailer(cfg.ThumbnailMaxDim, cfg.ThumbnailQuality))
+	}
+	store.StartRetention(storage.RetentionPolicy{MaxAge: cfg.RetentionMaxAge, MaxBytes: cfg.RetentionMaxBytes, MaxFiles: cfg.RetentionMaxFiles, Interval: cfg.RetentionInterval})
+	store.StartScrub(cfg.ScrubInterval, cfg.ScrubQuarantine)
+	store.StartHealth(storage.HealthPolicy{MinFreeBytes: cfg.StorageMinFreeMB * 1024 * 1024, Interval: cfg.HealthInterval})
+	uploads, err := storage.NewUploadStore(cfg.DataDir, store, cfg.MaxUploadBytes(), cfg.UploadExpiry, metrics)
+	if err != nil {
+		logger.Fatal("failed to init resumable uploads", zap.Error(err))
+	}
 	sessions := session.NewManager(cfg.SessionPolicy)
+	if len(cfg.WebhookURLs) > 0 {
+		hooks, err := webhook.NewDispatcher(webhook.Options{DataDir: cfg.DataDir, URLs: cfg.WebhookURLs, Secret: cfg.WebhookSecret, MaxAttempts: cfg.WebhookMaxAttempts, Timeout: cfg.WebhookTimeout, MaxQueue: cfg.WebhookMaxQueue, MaxDead: cfg.WebhookMaxDead, Metrics: metrics, Logger: logger})
+		if err != nil {
+			logger.Fatal("failed to init webhooks", zap.Error(err))
+		}
+		defer hooks.Close()
+		enqueue := func(eventType string, data any) {
+			if err := hooks.Enqueue(eventType, data); err != nil {
+				logger.Error("webhook enqueue failed", zap.String("event", eventType), zap.Error(err))
+			}
+		}
+		store.Subscribe(func(ev storage.Event) {
+			if ev.Type == storage.EventFrameSaved {
+				enqueue(ev.Type, ev.Frame)
+			}
+		})
+		sessions.Observe(func(ev session.Event) { enqueue(ev.Type, ev) })
+	}
 	webrtcSvc, err := wrtc.NewService(cfg, logger, metrics, sessions, store)
 	if err != nil {
 		logger.Fatal("failed to init webrtc", zap.Error(err))
 	}
-	router := httpapi.NewRouter(cfg, logger, metrics, store, sessions, webrtcSvc)
+	router := httpapi.NewRouter(cfg, logger, metrics, store, uploads, sessions, webrtcSvc)
 
 	srv := &http.Server{Addr: cfg.HTTPAddr, Handler: router, ReadHeaderTimeout: cfg.ReadHeaderTimeout, ReadTimeout: cfg.ReadTimeout, WriteTimeout: cfg.WriteTimeout, IdleTimeout: cfg.IdleTimeout}
 	go func() {
@@ -66,3 +116,25 @@ func main() {
 	}
 	logger.Info("server stopped", zap.Duration("grace", cfg.ShutdownGracePeriod), zap.Time("at", time.Now().UTC()))
 }
+
+// newBackend returns the configured blob backend, or nil for the default
+// local one.
+func newBackend(cfg config.Config) (storage.Backend, error) {
+	if cfg.StorageBackend != config.StorageBackendS3 {
+		return nil, nil
+	}
+	return storage.NewS3Backend(storage.S3Options{Endpoint: cfg.S3Endpoint, Region: cfg.S3Region, Bucket: cfg.S3Bucket, AccessKeyID: cfg.S3AccessKeyID, SecretAccessKey: cfg.S3SecretAccessKey, Prefix: cfg.S3Prefix, PathStyle: cfg.S3PathStyle})
+}
+
+// newKeyring loads the encryption keys, or returns nil when encryption at
+// rest is disabled.
+func newKeyring(cfg config.Config) (*storage.Keyring, error) {
+	switch {
+	case cfg.EncryptionKeyFile != "":
+		return storage.LoadKeyring(cfg.EncryptionKeyFile, cfg.EncryptionKeyID)
+	case cfg.EncryptionKeys != "":
+		return storage.ParseKeyring(cfg.EncryptionKeys, cfg.EncryptionKeyID)
+	default:
+		return nil, nil
+	}
+}
@@ -2,6 +2,7 @@ package config
 
 import (
 	"fmt"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
@@ -15,10 +16,51 @@ const (
 	SessionPolicyKickPrevious SessionPolicy = "kick_previous"
 )
 
+type StorageLayout string
+
+const (
+	StorageLayoutFlat   StorageLayout = "flat"
+	StorageLayoutDaily  StorageLayout = "daily"
+	StorageLayoutHourly StorageLayout = "hourly"
+)
+
+type StorageBackend string
+
+const (
+	StorageBackendLocal StorageBackend = "local"
+	StorageBackendS3    StorageBackend = "s3"
+)
+
+type PHashMode string
+
+const (
+	PHashModeOff  PHashMode = "off"
+	PHashModeFlag PHashMode = "flag"
+	PHashModeSkip PHashMode = "skip"
+)
+
+type TimestampPolicy string
+
+const (
+	TimestampPolicyFlag   TimestampPolicy = "flag"
+	TimestampPolicyReject TimestampPolicy = "reject"
+)
+
 type Config struct {
 	HTTPAddr            string
 	DataDir             string
+	StorageLayout       StorageLayout
+	StorageBackend      StorageBackend
+	S3Endpoint          string
+	S3Region            string
+	S3Bucket            string
+	S3AccessKeyID       string
+	S3SecretAccessKey   string
+	S3Prefix            string
+	S3PathStyle         bool
 	MaxUploadMB         int64
+	BatchMaxFrames      int
+	UploadExpiry        time.Duration
 	PSK                 string
 	PSKHeader           string
 	AllowNoPSK          bool
@@ -48,9 +90,44 @@ type Config struct {
 	RateLimitTTL        time.Duration
 	IdempotencyTTL      time.Duration
 	IdempotencyMax      int
+	RetentionMaxAge     time.Duration
+	RetentionMaxBytes   int64
+	RetentionMaxFiles   int
+	RetentionInterval   time.Duration
+	MaxImagePixels      int64
+	ThumbnailMaxDim     int
+	ThumbnailQuality    int
+	PHashMode           PHashMode
+	PHashThreshold      int
+	MotionThreshold     float64
+	StripMetadata       bool
+	TimestampPolicy     TimestampPolicy
+	WebhookURLs         []string
+	WebhookSecret       string
+	WebhookMaxAttempts  int
+	WebhookTimeout      time.Duration
+	WebhookMaxQueue     int
+	WebhookMaxDead      int
+	EncryptionKeyFile   string
+	EncryptionKeys      string
+	EncryptionKeyID     string
+	ScrubInterval       time.Duration
+	ScrubQuarantine     bool
+	StorageMinFreeMB    int64
+	HealthInterval      time.Duration
 }
 
 func Load() (Config, error) {
+	return load(true)
+}
+
+// LoadOffline loads the configuration for the offline subcommands, which
+// never serve HTTP and therefore do not need ERMETE_PSK.
+func LoadOffline() (Config, error) {
+	return load(false)
+}
+
+func load(requirePSK bool) (Config, error) {
 	cfg := Config{
 		HTTPAddr:            getEnv("HTTP_ADDR", ":8080"),
 		DataDir:             getEnv("DATA_DIR", "/data"),
@@ -72,11 +149,23 @@ func Load() (Config, error) {
 		RateLimitTTL:        30 * time.Minute,
 		IdempotencyTTL:      10 * time.Minute,
 		IdempotencyMax:      50000,
+		BatchMaxFrames:      100,
+		UploadExpiry:        24 * time.Hour,
+		RetentionInterval:   5 * time.Minute,
+		MaxImagePixels:      40_000_000,
+		ThumbnailQuality:    75,
+		PHashThreshold:      5,
+		WebhookMaxAttempts:  8,
+		WebhookTimeout:      10 * time.Second,
+		WebhookMaxQueue:     10000,
+		WebhookMaxDead:      10000,
+		StorageMinFreeMB:    256,
+		HealthInterval:      30 * time.Second,
 	}
 
 	cfg.PSK = os.Getenv("ERMETE_PSK")
 	cfg.AllowNoPSK = parseBoolEnv("ERMETE_ALLOW_NO_PSK", false)
-	if cfg.PSK == "" && !cfg.AllowNoPSK {
+	if requirePSK && cfg.PSK == "" && !cfg.AllowNoPSK {
 		return Config{}, fmt.Errorf("ERMETE_PSK is required unless ERMETE_ALLOW_NO_PSK=true")
 	}
 	if strings.TrimSpace(cfg.PSKHeader) == "" {
@@ -95,6 +184,20 @@ func Load() (Config, error) {
 	}
 	cfg.MaxUploadMB = maxUploadMB
 
+	if v, err := parseIntEnv("BATCH_MAX_FRAMES", cfg.BatchMaxFrames); err != nil {
+		return Config{}, err
+	} else if v <= 0 {
+		return Config{}, fmt.Errorf("BATCH_MAX_FRAMES must be > 0")
+	} else {
+		cfg.BatchMaxFrames = v
+	}
+
+	if v, err := parseDurationEnv("UPLOAD_EXPIRY", cfg.UploadExpiry); err != nil {
+		return Config{}, err
+	} else {
+		cfg.UploadExpiry = v
+	}
+
 	originsRaw := os.Getenv("CORS_ALLOWED_ORIGINS")
 	if originsRaw != "" {
 		cfg.CORSAllowedOrigins = splitCSV(originsRaw)
@@ -110,6 +213,32 @@ func Load() (Config, error) {
 		return Config{}, fmt.Errorf("invalid SESSION_POLICY: %s", policy)
 	}
 
+	layout := StorageLayout(getEnv("STORAGE_LAYOUT", string(StorageLayoutFlat)))
+	switch layout {
+	case StorageLayoutFlat, StorageLayoutDaily, StorageLayoutHourly:
+		cfg.StorageLayout = layout
+	default:
+		return Config{}, fmt.Errorf("invalid STORAGE_LAYOUT: %s", layout)
+	}
+
+	backend := StorageBackend(getEnv("STORAGE_BACKEND", string(StorageBackendLocal)))
+	switch backend {
+	case StorageBackendLocal, StorageBackendS3:
+		cfg.StorageBackend = backend
+	default:
+		return Config{}, fmt.Errorf("invalid STORAGE_BACKEND: %s", backend)
+	}
+	cfg.S3Endpoint = os.Getenv("S3_ENDPOINT")
+	cfg.S3Region = getEnv("S3_REGION", "us-east-1")
+	cfg.S3Bucket = os.Getenv("S3_BUCKET")
+	cfg.S3AccessKeyID = os.Getenv("S3_ACCESS_KEY_ID")
+	cfg.S3SecretAccessKey = os.Getenv("S3_SECRET_ACCESS_KEY")
+	cfg.S3Prefix = os.Getenv("S3_PREFIX")
+	cfg.S3PathStyle = parseBoolEnv("S3_PATH_STYLE", false)
+	if cfg.StorageBackend == StorageBackendS3 && (cfg.S3Endpoint == "" || cfg.S3Bucket == "") {
+		return Config{}, fmt.Errorf("S3_ENDPOINT and S3_BUCKET are required when STORAGE_BACKEND=s3")
+	}
+
 	cfg.WebRTCStunURLs = splitCSV(os.Getenv("WEBRTC_STUN_URLS"))
 	cfg.WebRTCTurnURLs = splitCSV(os.Getenv("WEBRTC_TURN_URLS"))
 	cfg.WebRTCTurnUser = os.Getenv("WEBRTC_TURN_USER")
@@ -141,6 +270,147 @@ func Load() (Config, error) {
 		cfg.IdempotencyMax = v
 	}
 
+	if v, err := parseDurationEnv("RETENTION_MAX_AGE", 0); err != nil {
+		return Config{}, err
+	} else {
+		cfg.RetentionMaxAge = v
+	}
+	if v, err := parseInt64Env("RETENTION_MAX_BYTES", 0); err != nil {
+		return Config{}, err
+	} else if v < 0 {
+		return Config{}, fmt.Errorf("RETENTION_MAX_BYTES must be >= 0")
+	} else {
+		cfg.RetentionMaxBytes = v
+	}
+	if v, err := parseIntEnv("RETENTION_MAX_FILES", 0); err != nil {
+		return Config{}, err
+	} else if v < 0 {
+		return Config{}, fmt.Errorf("RETENTION_MAX_FILES must be >= 0")
+	} else {
+		cfg.RetentionMaxFiles = v
+	}
+	if v, err := parseDurationEnv("RETENTION_INTERVAL", cfg.RetentionInterval); err != nil {
+		return Config{}, err
+	} else {
+		cfg.RetentionInterval = v
+	}
+
+	if v, err := parseInt64Env("MAX_IMAGE_PIXELS", cfg.MaxImagePixels); err != nil {
+		return Config{}, err
+	} else if v <= 0 {
+		return Config{}, fmt.Errorf("MAX_IMAGE_PIXELS must be > 0")
+	} else {
+		cfg.MaxImagePixels = v
+	}
+	if v, err := parseIntEnv("THUMBNAIL_MAX_DIM", 0); err != nil {
+		return Config{}, err
+	} else if v < 0 {
+		return Config{}, fmt.Errorf("THUMBNAIL_MAX_DIM must be >= 0")
+	} else {
+		cfg.ThumbnailMaxDim = v
+	}
+	if v, err := parseIntEnv("THUMBNAIL_QUALITY", cfg.ThumbnailQuality); err != nil {
+		return Config{}, err
+	} else if v < 1 || v > 100 {
+		return Config{}, fmt.Errorf("THUMBNAIL_QUALITY must be between 1 and 100")
+	} else {
+		cfg.ThumbnailQuality = v
+	}
+
+	phashMode := PHashMode(getEnv("PHASH_MODE", string(PHashModeOff)))
+	switch phashMode {
+	case PHashModeOff, PHashModeFlag, PHashModeSkip:
+		cfg.PHashMode = phashMode
+	default:
+		return Config{}, fmt.Errorf("invalid PHASH_MODE: %s", phashMode)
+	}
+	if v, err := parseIntEnv("PHASH_THRESHOLD", cfg.PHashThreshold); err != nil {
+		return Config{}, err
+	} else if v < 1 || v > 64 {
+		return Config{}, fmt.Errorf("PHASH_THRESHOLD must be between 1 and 64")
+	} else {
+		cfg.PHashThreshold = v
+	}
+	if v, err := parseFloatEnv("MOTION_THRESHOLD", 0); err != nil {
+		return Config{}, err
+	} else if v < 0 || v > 1 {
+		return Config{}, fmt.Errorf("MOTION_THRESHOLD must be between 0 and 1")
+	} else {
+		cfg.MotionThreshold = v
+	}
+	cfg.StripMetadata = parseBoolEnv("STRIP_METADATA", false)
+	timestampPolicy := TimestampPolicy(getEnv("TIMESTAMP_POLICY", string(TimestampPolicyFlag)))
+	switch timestampPolicy {
+	case TimestampPolicyFlag, TimestampPolicyReject:
+		cfg.TimestampPolicy = timestampPolicy
+	default:
+		return Config{}, fmt.Errorf("invalid TIMESTAMP_POLICY: %s", timestampPolicy)
+	}
+
+	cfg.WebhookURLs = splitCSV(os.Getenv("WEBHOOK_URLS"))
+	for _, raw := range cfg.WebhookURLs {
+		if u, err := url.Parse(raw); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return Config{}, fmt.Errorf("invalid WEBHOOK_URLS entry: %s", raw)
+		}
+	}
+	cfg.WebhookSecret = os.Getenv("WEBHOOK_SECRET")
+	if v, err := parseIntEnv("WEBHOOK_MAX_ATTEMPTS", cfg.WebhookMaxAttempts); err != nil {
+		return Config{}, err
+	} else if v <= 0 {
+		return Config{}, fmt.Errorf("WEBHOOK_MAX_ATTEMPTS must be > 0")
+	} else {
+		cfg.WebhookMaxAttempts = v
+	}
+	if v, err := parseDurationEnv("WEBHOOK_TIMEOUT", cfg.WebhookTimeout); err != nil {
+		return Config{}, err
+	} else {
+		cfg.WebhookTimeout = v
+	}
+	if v, err := parseIntEnv("WEBHOOK_MAX_QUEUE", cfg.WebhookMaxQueue); err != nil {
+		return Config{}, err
+	} else if v <= 0 {
+		return Config{}, fmt.Errorf("WEBHOOK_MAX_QUEUE must be > 0")
+	} else {
+		cfg.WebhookMaxQueue = v
+	}
+	if v, err := parseIntEnv("WEBHOOK_MAX_DEAD", cfg.WebhookMaxDead); err != nil {
+		return Config{}, err
+	} else if v <= 0 {
+		return Config{}, fmt.Errorf("WEBHOOK_MAX_DEAD must be > 0")
+	} else {
+		cfg.WebhookMaxDead = v
+	}
+
+	cfg.EncryptionKeyFile = os.Getenv("ENCRYPTION_KEY_FILE")
+	cfg.EncryptionKeys = os.Getenv("ENCRYPTION_KEYS")
+	cfg.EncryptionKeyID = os.Getenv("ENCRYPTION_KEY_ID")
+	if cfg.EncryptionKeyFile != "" && cfg.EncryptionKeys != "" {
+		return Config{}, fmt.Errorf("set only one of ENCRYPTION_KEY_FILE and ENCRYPTION_KEYS")
+	}
+	if cfg.EncryptionKeyID != "" && !cfg.EncryptionEnabled() {
+		return Config{}, fmt.Errorf("ENCRYPTION_KEY_ID requires ENCRYPTION_KEY_FILE or ENCRYPTION_KEYS")
+	}
+
+	if v, err := parseDurationEnv("SCRUB_INTERVAL", 0); err != nil {
+		return Config{}, err
+	} else {
+		cfg.ScrubInterval = v
+	}
+	cfg.ScrubQuarantine = parseBoolEnv("SCRUB_QUARANTINE", false)
+
+	if v, err := parseInt64Env("STORAGE_MIN_FREE_MB", cfg.StorageMinFreeMB); err != nil {
+		return Config{}, err
+	} else if v < 0 {
+		return Config{}, fmt.Errorf("STORAGE_MIN_FREE_MB must be >= 0")
+	} else {
+		cfg.StorageMinFreeMB = v
+	}
+	if v, err := parseDurationEnv("STORAGE_HEALTH_INTERVAL", cfg.HealthInterval); err != nil {
+		return Config{}, err
+	} else {
+		cfg.HealthInterval = v
+	}
+
 	return cfg, nil
 }
 
@@ -148,6 +418,10 @@ func (c Config) MaxUploadBytes() int64 {
 	return c.MaxUploadMB * 1024 * 1024
 }
 
+func (c Config) EncryptionEnabled() bool {
+	return c.EncryptionKeyFile != "" || c.EncryptionKeys != ""
+}
+
 func parseInt64Env(name string, defaultVal int64) (int64, error) {
 	raw := os.Getenv(name)
 	if raw == "" {
@@ -172,6 +446,18 @@ func parseIntEnv(name string, defaultVal int) (int, error) {
 	return v, nil
 }
 
+func parseFloatEnv(name string, defaultVal float64) (float64, error) {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return defaultVal, nil
+	}
+	v, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return v, nil
+}
+
 func parseDurationEnv(name string, defaultVal time.Duration) (time.Duration, error) {
 	raw := os.Getenv(name)
 	if raw == "" {
@@ -2,6 +2,7 @@ package config
 
 import (
 	"testing"
+	"time"
 )
 
 func TestLoadDefaults(t *testing.T) {
@@ -59,3 +60,180 @@ func TestPSKRequiredUnlessAllowed(t *testing.T) {
 		t.Fatalf("unexpected error with allow-no-psk: %v", err)
 	}
 }
+
+func TestStorageLayout(t *testing.T) {
+	t.Setenv("ERMETE_ALLOW_NO_PSK", "true")
+	t.Setenv("STORAGE_LAYOUT", "hourly")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.StorageLayout != StorageLayoutHourly {
+		t.Fatalf("unexpected layout: %s", cfg.StorageLayout)
+	}
+	t.Setenv("STORAGE_LAYOUT", "weekly")
+	if _, err := Load(); err == nil {
+		t.Fatal("expected error for invalid layout")
+	}
+}
+
+func TestStorageBackendS3RequiresBucket(t *testing.T) {
+	t.Setenv("ERMETE_ALLOW_NO_PSK", "true")
+	t.Setenv("STORAGE_BACKEND", "s3")
+	t.Setenv("S3_ENDPOINT", "http://minio:9000")
+	t.Setenv("S3_BUCKET", "")
+	if _, err := Load(); err == nil {
+		t.Fatal("expected error for missing bucket")
+	}
+	t.Setenv("S3_BUCKET", "frames")
+	t.Setenv("S3_PATH_STYLE", "true")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.StorageBackend != StorageBackendS3 || cfg.S3Region != "us-east-1" || !cfg.S3PathStyle {
+		t.Fatalf("unexpected s3 config: %+v", cfg)
+	}
+	t.Setenv("STORAGE_BACKEND", "gcs")
+	if _, err := Load(); err == nil {
+		t.Fatal("expected error for invalid backend")
+	}
+}
+
+func TestPHashConfig(t *testing.T) {
+	t.Setenv("ERMETE_ALLOW_NO_PSK", "true")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.PHashMode != PHashModeOff || cfg.PHashThreshold != 5 {
+		t.Fatalf("unexpected phash defaults: %s %d", cfg.PHashMode, cfg.PHashThreshold)
+	}
+	t.Setenv("PHASH_MODE", "skip")
+	t.Setenv("PHASH_THRESHOLD", "10")
+	if cfg, err = Load(); err != nil || cfg.PHashMode != PHashModeSkip || cfg.PHashThreshold != 10 {
+		t.Fatalf("unexpected phash config: %+v err=%v", cfg, err)
+	}
+	t.Setenv("PHASH_THRESHOLD", "0")
+	if _, err := Load(); err == nil {
+		t.Fatal("expected error for zero threshold")
+	}
+	t.Setenv("PHASH_THRESHOLD", "5")
+	t.Setenv("PHASH_MODE", "drop")
+	if _, err := Load(); err == nil {
+		t.Fatal("expected error for invalid mode")
+	}
+}
+
+func TestMaxImagePixels(t *testing.T) {
+	t.Setenv("ERMETE_ALLOW_NO_PSK", "true")
+	cfg, err := Load()
+	if err != nil || cfg.MaxImagePixels != 40_000_000 {
+		t.Fatalf("unexpected default: %d err=%v", cfg.MaxImagePixels, err)
+	}
+	t.Setenv("MAX_IMAGE_PIXELS", "0")
+	if _, err := Load(); err == nil {
+		t.Fatal("expected error for zero pixel limit")
+	}
+}
+
+func TestMotionThreshold(t *testing.T) {
+	t.Setenv("ERMETE_ALLOW_NO_PSK", "true")
+	cfg, err := Load()
+	if err != nil || cfg.MotionThreshold != 0 {
+		t.Fatalf("expected motion detection off by default: %v err=%v", cfg.MotionThreshold, err)
+	}
+	t.Setenv("MOTION_THRESHOLD", "0.08")
+	if cfg, err = Load(); err != nil || cfg.MotionThreshold != 0.08 {
+		t.Fatalf("unexpected threshold: %v err=%v", cfg.MotionThreshold, err)
+	}
+	for _, bad := range []string{"1.5", "-0.1", "high"} {
+		t.Setenv("MOTION_THRESHOLD", bad)
+		if _, err := Load(); err == nil {
+			t.Fatalf("expected error for MOTION_THRESHOLD=%s", bad)
+		}
+	}
+}
+
+func TestWebhookConfig(t *testing.T) {
+	t.Setenv("ERMETE_ALLOW_NO_PSK", "true")
+	t.Setenv("WEBHOOK_URLS", "https://hooks.example/a, http://10.0.0.2:9000/b")
+	t.Setenv("WEBHOOK_MAX_ATTEMPTS", "3")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.WebhookURLs) != 2 || cfg.WebhookURLs[1] != "http://10.0.0.2:9000/b" || cfg.WebhookMaxAttempts != 3 || cfg.WebhookTimeout != 10*time.Second || cfg.WebhookMaxQueue != 10000 || cfg.WebhookMaxDead != 10000 {
+		t.Fatalf("unexpected webhook config: %+v", cfg)
+	}
+	t.Setenv("WEBHOOK_URLS", "ftp://hooks.example/a")
+	if _, err := Load(); err == nil {
+		t.Fatal("expected error for a non-HTTP webhook URL")
+	}
+}
+
+func TestEncryptionConfig(t *testing.T) {
+	t.Setenv("ERMETE_ALLOW_NO_PSK", "true")
+	cfg, err := Load()
+	if err != nil || cfg.EncryptionEnabled() {
+		t.Fatalf("expected encryption off by default: %+v err=%v", cfg, err)
+	}
+	t.Setenv("ENCRYPTION_KEY_ID", "k1")
+	if _, err := Load(); err == nil {
+		t.Fatal("expected error for ENCRYPTION_KEY_ID without keys")
+	}
+	t.Setenv("ENCRYPTION_KEY_FILE", "/run/secrets/ermete-keys")
+	if cfg, err = Load(); err != nil || !cfg.EncryptionEnabled() || cfg.EncryptionKeyID != "k1" {
+		t.Fatalf("unexpected encryption config: %+v err=%v", cfg, err)
+	}
+	t.Setenv("ENCRYPTION_KEYS", "k1:AAAA")
+	if _, err := Load(); err == nil {
+		t.Fatal("expected error when both key sources are set")
+	}
+}
+
+func TestScrubConfig(t *testing.T) {
+	t.Setenv("ERMETE_ALLOW_NO_PSK", "true")
+	cfg, err := Load()
+	if err != nil || cfg.ScrubInterval != 0 || cfg.ScrubQuarantine {
+		t.Fatalf("expected scrub off by default: %+v err=%v", cfg, err)
+	}
+	t.Setenv("SCRUB_INTERVAL", "24h")
+	t.Setenv("SCRUB_QUARANTINE", "true")
+	if cfg, err = Load(); err != nil || cfg.ScrubInterval != 24*time.Hour || !cfg.ScrubQuarantine {
+		t.Fatalf("unexpected scrub config: %+v err=%v", cfg, err)
+	}
+}
+
+func TestStorageHealthConfig(t *testing.T) {
+	t.Setenv("ERMETE_ALLOW_NO_PSK", "true")
+	cfg, err := Load()
+	if err != nil || cfg.StorageMinFreeMB != 256 || cfg.HealthInterval != 30*time.Second {
+		t.Fatalf("unexpected defaults: %+v err=%v", cfg, err)
+	}
+	t.Setenv("STORAGE_MIN_FREE_MB", "0")
+	t.Setenv("STORAGE_HEALTH_INTERVAL", "5s")
+	if cfg, err = Load(); err != nil || cfg.StorageMinFreeMB != 0 || cfg.HealthInterval != 5*time.Second {
+		t.Fatalf("unexpected health config: %+v err=%v", cfg, err)
+	}
+	t.Setenv("STORAGE_MIN_FREE_MB", "-1")
+	if _, err := Load(); err == nil {
+		t.Fatal("expected error for negative STORAGE_MIN_FREE_MB")
+	}
+}
+
+func TestTimestampPolicy(t *testing.T) {
+	t.Setenv("ERMETE_ALLOW_NO_PSK", "true")
+	cfg, err := Load()
+	if err != nil || cfg.TimestampPolicy != TimestampPolicyFlag {
+		t.Fatalf("expected flag by default: %v err=%v", cfg.TimestampPolicy, err)
+	}
+	t.Setenv("TIMESTAMP_POLICY", "reject")
+	if cfg, err = Load(); err != nil || cfg.TimestampPolicy != TimestampPolicyReject {
+		t.Fatalf("unexpected policy: %v err=%v", cfg.TimestampPolicy, err)
+	}
+	t.Setenv("TIMESTAMP_POLICY", "ignore")
+	if _, err := Load(); err == nil {
+		t.Fatal("expected error for an unknown TIMESTAMP_POLICY")
+	}
+}
@@ -0,0 +1,258 @@
+package export
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+
+	"ermete/internal/storage"
+)
+
+var ErrNoFrames = errors.New("no frames to export")
+
+const (
+	DefaultTimelapseFPS     = 10
+	MaxTimelapseFPS         = 60
+	DefaultTimelapseQuality = 85
+)
+
+type TimelapseOptions struct {
+	FPS     int
+	Quality int
+	// Overlay stamps each frame with its capture timestamp (X-Timestamp,
+	// falling back to the received time).
+	Overlay bool
+	// TempDir holds the transcoded frames until the AVI is assembled and is
+	// created if missing; empty means os.TempDir. Callers pass
+	// TimelapseTempDir, as the spool can be as large as the selected frames.
+	TempDir string
+}
+
+// TimelapseTempDir is the spool directory under dataDir.
+func TimelapseTempDir(dataDir string) string {
+	return filepath.Join(dataDir, "tmp")
+}
+
+type TimelapseResult struct {
+	Frames  int
+	Skipped int
+	Width   int
+	Height  int
+}
+
+// Timelapse assembles the JPEG, PNG and GIF frames matching q into an MJPEG
+// AVI written to w. Frames are first transcoded one at a time into a
+// temporary file, because the AVI headers need every chunk size up front;
+// nothing is written to w when no frame qualifies, so callers can still
+// report ErrNoFrames. Every frame is fitted to the size of the first one;
+// JPEGs already at that size are copied without re-encoding unless an
+// overlay is requested.
+func Timelapse(w io.Writer, store *storage.FrameStore, q storage.FrameQuery, opts TimelapseOptions) (TimelapseResult, error) {
+	if opts.FPS <= 0 {
+		opts.FPS = DefaultTimelapseFPS
+	}
+	if opts.Quality <= 0 || opts.Quality > 100 {
+		opts.Quality = DefaultTimelapseQuality
+	}
+	if opts.TempDir != "" {
+		if err := os.MkdirAll(opts.TempDir, 0o755); err != nil {
+			return TimelapseResult{}, fmt.Errorf("create timelapse spool dir: %w", err)
+		}
+	}
+	tmp, err := os.CreateTemp(opts.TempDir, ".ermete-timelapse-*")
+	if err != nil {
+		return TimelapseResult{}, fmt.Errorf("create timelapse spool: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	var res TimelapseResult
+	var sizes []int64
+	err = store.WalkFrames(q, func(m storage.FrameMeta) error {
+		if m.Format != "jpeg" && m.Format != "png" && m.Format != "gif" {
+			res.Skipped++
+			return nil
+		}
+		// frames indexed before the upload limit existed are never decoded
+		if int64(m.Width)*int64(m.Height) > store.MaxImagePixels() {
+			res.Skipped++
+			return nil
+		}
+		if res.Width == 0 {
+			res.Width, res.Height = m.Width, m.Height
+		}
+		n, err := spoolTimelapseFrame(tmp, store, m, res.Width, res.Height, opts)
+		if errors.Is(err, storage.ErrFrameNotFound) || errors.Is(err, errUndecodable) {
+			res.Skipped++
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		sizes = append(sizes, n)
+		return nil
+	})
+	if err != nil {
+		return res, err
+	}
+	res.Frames = len(sizes)
+	if res.Frames == 0 {
+		return res, ErrNoFrames
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return res, err
+	}
+	return res, writeAVI(w, tmp, sizes, res.Width, res.Height, opts.FPS)
+}
+
+var errUndecodable = errors.New("undecodable frame")
+
+func spoolTimelapseFrame(dst io.Writer, store *storage.FrameStore, m storage.FrameMeta, width, height int, opts TimelapseOptions) (int64, error) {
+	_, f, err := store.OpenFrame(m.FileName)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	if m.Format == "jpeg" && m.Width == width && m.Height == height && !opts.Overlay {
+		return io.Copy(dst, f)
+	}
+
+	var src image.Image
+	switch m.Format {
+	case "jpeg":
+		src, err = jpeg.Decode(f)
+	case "png":
+		src, err = png.Decode(f)
+	case "gif":
+		src, err = gif.Decode(f)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s: %v", errUndecodable, m.FileName, err)
+	}
+	img := fit(src, width, height)
+	if opts.Overlay {
+		drawLabel(img, m.CaptureTime().UTC().Format("2006-01-02 15:04:05Z"))
+	}
+	cw := &countingWriter{w: dst}
+	if err := jpeg.Encode(cw, img, &jpeg.Options{Quality: opts.Quality}); err != nil {
+		return 0, err
+	}
+	return cw.n, nil
+}
+
+// fit scales src to exactly width x height with nearest-neighbour sampling.
+func fit(src image.Image, width, height int) *image.RGBA {
+	b := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	if b.Dx() == width && b.Dy() == height {
+		draw.Draw(dst, dst.Bounds(), src, b.Min, draw.Src)
+		return dst
+	}
+	for y := 0; y < height; y++ {
+		sy := b.Min.Y + y*b.Dy()/height
+		for x := 0; x < width; x++ {
+			dst.Set(x, y, src.At(b.Min.X+x*b.Dx()/width, sy))
+		}
+	}
+	return dst
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+const (
+	aviHeaderListSize = 4 + (8 + 56) + (8 + 4 + (8 + 56) + (8 + 40))
+	aviKeyframe       = 0x10
+	aviHasIndex       = 0x10
+)
+
+// writeAVI writes a RIFF AVI with a single MJPEG video stream whose chunks
+// are read in order from frames, followed by an idx1 index.
+func writeAVI(w io.Writer, frames io.Reader, sizes []int64, width, height, fps int) error {
+	moviSize := int64(4)
+	var maxSize int64
+	for _, n := range sizes {
+		moviSize += 8 + n + n%2
+		maxSize = max(maxSize, n)
+	}
+	idxSize := int64(16 * len(sizes))
+	riffSize := 4 + (8 + aviHeaderListSize) + (8 + moviSize) + (8 + idxSize)
+	if riffSize > 0xffffffff {
+		return fmt.Errorf("timelapse too large for an AVI file (%d bytes)", riffSize)
+	}
+
+	var h bytes.Buffer
+	le := func(vs ...any) {
+		for _, v := range vs {
+			_ = binary.Write(&h, binary.LittleEndian, v)
+		}
+	}
+	h.WriteString("RIFF")
+	le(uint32(riffSize))
+	h.WriteString("AVI LIST")
+	le(uint32(aviHeaderListSize))
+	h.WriteString("hdrlavih")
+	le(uint32(56), uint32(1000000/fps), uint32(maxSize*int64(fps)), uint32(0), uint32(aviHasIndex), uint32(len(sizes)), uint32(0), uint32(1), uint32(maxSize), uint32(width), uint32(height), [4]uint32{})
+	h.WriteString("LIST")
+	le(uint32(4 + (8 + 56) + (8 + 40)))
+	h.WriteString("strlstrh")
+	le(uint32(56))
+	h.WriteString("vidsMJPG")
+	le(uint32(0), uint16(0), uint16(0), uint32(0), uint32(1), uint32(fps), uint32(0), uint32(len(sizes)), uint32(maxSize), int32(-1), uint32(0), [4]uint16{0, 0, uint16(width), uint16(height)})
+	h.WriteString("strf")
+	le(uint32(40), uint32(40), int32(width), int32(height), uint16(1), uint16(24))
+	h.WriteString("MJPG")
+	le(uint32(width*height*3), int32(0), int32(0), uint32(0), uint32(0))
+	h.WriteString("LIST")
+	le(uint32(moviSize))
+	h.WriteString("movi")
+	if _, err := w.Write(h.Bytes()); err != nil {
+		return err
+	}
+
+	var chunk [8]byte
+	copy(chunk[:4], "00dc")
+	for _, n := range sizes {
+		binary.LittleEndian.PutUint32(chunk[4:], uint32(n))
+		if _, err := w.Write(chunk[:]); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(w, frames, n); err != nil {
+			return err
+		}
+		if n%2 == 1 {
+			if _, err := w.Write([]byte{0}); err != nil {
+				return err
+			}
+		}
+	}
+
+	h.Reset()
+	h.WriteString("idx1")
+	le(uint32(idxSize))
+	offset := int64(4)
+	for _, n := range sizes {
+		h.WriteString("00dc")
+		le(uint32(aviKeyframe), uint32(offset), uint32(n))
+		offset += 8 + n + n%2
+	}
+	_, err := w.Write(h.Bytes())
+	return err
+}
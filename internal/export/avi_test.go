@@ -0,0 +1,161 @@
+package export
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+	"time"
+
+	"ermete/internal/storage"
+)
+
+func testJPEG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i)
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+type aviChunk struct {
+	id   string
+	data []byte
+}
+
+// parseMovi returns the chunks of the movi list and the idx1 entries.
+func parseMovi(t *testing.T, data []byte) ([]aviChunk, []byte, []byte) {
+	t.Helper()
+	if string(data[0:4]) != "RIFF" || string(data[8:12]) != "AVI " {
+		t.Fatalf("not an AVI file")
+	}
+	if size := binary.LittleEndian.Uint32(data[4:8]); int(size) != len(data)-8 {
+		t.Fatalf("RIFF size %d does not match file size %d", size, len(data)-8)
+	}
+	var chunks []aviChunk
+	var hdrl, idx []byte
+	for off := 12; off < len(data); {
+		id, size := string(data[off:off+4]), int(binary.LittleEndian.Uint32(data[off+4:off+8]))
+		body := data[off+8 : off+8+size]
+		switch {
+		case id == "LIST" && string(body[:4]) == "hdrl":
+			hdrl = body
+		case id == "LIST" && string(body[:4]) == "movi":
+			for p := 4; p < len(body); {
+				n := int(binary.LittleEndian.Uint32(body[p+4 : p+8]))
+				chunks = append(chunks, aviChunk{id: string(body[p : p+4]), data: body[p+8 : p+8+n]})
+				p += 8 + n + n%2
+			}
+		case id == "idx1":
+			idx = body
+		}
+		off += 8 + size + size%2
+	}
+	return chunks, hdrl, idx
+}
+
+func TestTimelapseBuildsMJPEGAVI(t *testing.T) {
+	store := testStore(t)
+	jpg := testJPEG(t, 16, 8)
+	inputs := [][]byte{jpg, testPNG(t, 1), testJPEG(t, 32, 16)}
+	for i, data := range inputs {
+		ts := time.Date(2026, 1, 1, 10, 0, i, 0, time.UTC).Format(time.RFC3339)
+		if _, err := store.SaveFrame(storage.FrameInput{FrameID: "cam", Timestamp: ts}, bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	res, err := Timelapse(&buf, store, storage.FrameQuery{}, TimelapseOptions{FPS: 5, TempDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Frames != 3 || res.Width != 16 || res.Height != 8 {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+	chunks, hdrl, idx := parseMovi(t, buf.Bytes())
+	if len(chunks) != 3 || len(idx) != 3*16 {
+		t.Fatalf("expected 3 chunks and index entries, got %d and %d", len(chunks), len(idx)/16)
+	}
+	if !bytes.Equal(chunks[0].data, jpg) {
+		t.Fatal("expected matching JPEG to be copied unchanged")
+	}
+	for i, c := range chunks {
+		cfg, err := jpeg.DecodeConfig(bytes.NewReader(c.data))
+		if err != nil || c.id != "00dc" || cfg.Width != 16 || cfg.Height != 8 {
+			t.Fatalf("chunk %d: id=%s cfg=%+v err=%v", i, c.id, cfg, err)
+		}
+	}
+	// avih: microseconds per frame, then total frames at offset 16
+	avih := hdrl[12:]
+	if us := binary.LittleEndian.Uint32(avih[0:4]); us != 200000 {
+		t.Fatalf("unexpected frame duration %d", us)
+	}
+	if n := binary.LittleEndian.Uint32(avih[16:20]); n != 3 {
+		t.Fatalf("unexpected total frames %d", n)
+	}
+}
+
+func TestTimelapseOverlayAndEmpty(t *testing.T) {
+	store := testStore(t)
+	var buf bytes.Buffer
+	if _, err := Timelapse(&buf, store, storage.FrameQuery{}, TimelapseOptions{TempDir: t.TempDir()}); !errors.Is(err, ErrNoFrames) || buf.Len() != 0 {
+		t.Fatalf("expected ErrNoFrames with no output, got %v (%d bytes)", err, buf.Len())
+	}
+
+	jpg := testJPEG(t, 240, 120)
+	if _, err := store.SaveFrame(storage.FrameInput{FrameID: "cam", Timestamp: "2026-01-01T10:00:00Z"}, bytes.NewReader(jpg)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Timelapse(&buf, store, storage.FrameQuery{}, TimelapseOptions{Overlay: true, TempDir: t.TempDir()}); err != nil {
+		t.Fatal(err)
+	}
+	chunks, _, _ := parseMovi(t, buf.Bytes())
+	if len(chunks) != 1 || bytes.Equal(chunks[0].data, jpg) {
+		t.Fatal("expected the overlay to re-encode the frame")
+	}
+	img, err := jpeg.Decode(bytes.NewReader(chunks[0].data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the label box is black, the test pattern is not
+	if r, g, b, _ := img.At(1, 119).RGBA(); r > 0x2000 || g > 0x2000 || b > 0x2000 {
+		t.Fatalf("expected a dark label box, got %v", color.RGBA64{uint16(r), uint16(g), uint16(b), 0xffff})
+	}
+}
+
+func TestTimelapseSkipsFramesOverPixelLimit(t *testing.T) {
+	dir := t.TempDir()
+	store, err := storage.NewFrameStore(dir, 10*time.Minute, 100, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, data := range [][]byte{testJPEG(t, 64, 64), testJPEG(t, 16, 8)} {
+		if _, err := store.SaveFrame(storage.FrameInput{FrameID: "cam"}, bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = store.Close()
+
+	// frames indexed under a larger limit are skipped, not decoded
+	limited, err := storage.OpenFrameStore(storage.Options{DataDir: dir, MaxImagePixels: 1000, ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	res, err := Timelapse(&buf, limited, storage.FrameQuery{}, TimelapseOptions{TempDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Frames != 1 || res.Skipped != 1 || res.Width != 16 || res.Height != 8 {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+}
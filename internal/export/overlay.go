@@ -0,0 +1,51 @@
+package export
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// glyphs is a 5x7 bitmap font covering the characters of a timestamp; each
+// row uses the low five bits, most significant bit on the left.
+var glyphs = map[rune][7]uint8{
+	'0': {0x0e, 0x11, 0x13, 0x15, 0x19, 0x11, 0x0e},
+	'1': {0x04, 0x0c, 0x04, 0x04, 0x04, 0x04, 0x0e},
+	'2': {0x0e, 0x11, 0x01, 0x02, 0x04, 0x08, 0x1f},
+	'3': {0x1f, 0x02, 0x04, 0x02, 0x01, 0x11, 0x0e},
+	'4': {0x02, 0x06, 0x0a, 0x12, 0x1f, 0x02, 0x02},
+	'5': {0x1f, 0x10, 0x1e, 0x01, 0x01, 0x11, 0x0e},
+	'6': {0x06, 0x08, 0x10, 0x1e, 0x11, 0x11, 0x0e},
+	'7': {0x1f, 0x01, 0x02, 0x04, 0x08, 0x08, 0x08},
+	'8': {0x0e, 0x11, 0x11, 0x0e, 0x11, 0x11, 0x0e},
+	'9': {0x0e, 0x11, 0x11, 0x0f, 0x01, 0x02, 0x0c},
+	'-': {0x00, 0x00, 0x00, 0x1f, 0x00, 0x00, 0x00},
+	':': {0x00, 0x0c, 0x0c, 0x00, 0x0c, 0x0c, 0x00},
+	'.': {0x00, 0x00, 0x00, 0x00, 0x00, 0x0c, 0x0c},
+	'Z': {0x1f, 0x01, 0x02, 0x04, 0x08, 0x10, 0x1f},
+	' ': {},
+}
+
+// drawLabel writes text in white on a black box in the bottom-left corner,
+// scaled with the image height so it stays legible on large frames.
+func drawLabel(img draw.Image, text string) {
+	b := img.Bounds()
+	scale := max(1, b.Dy()/240)
+	pad := 2 * scale
+	runes := []rune(text)
+	box := image.Rect(b.Min.X, b.Max.Y-7*scale-2*pad, b.Min.X+len(runes)*6*scale+2*pad, b.Max.Y).Intersect(b)
+	draw.Draw(img, box, image.NewUniform(color.Black), image.Point{}, draw.Src)
+	x0, y0 := box.Min.X+pad, box.Min.Y+pad
+	for i, r := range runes {
+		g := glyphs[r]
+		for row := 0; row < 7; row++ {
+			for col := 0; col < 5; col++ {
+				if g[row]&(0x10>>col) == 0 {
+					continue
+				}
+				px := image.Rect(x0+(i*6+col)*scale, y0+row*scale, x0+(i*6+col+1)*scale, y0+(row+1)*scale)
+				draw.Draw(img, px.Intersect(b), image.NewUniform(color.White), image.Point{}, draw.Src)
+			}
+		}
+	}
+}
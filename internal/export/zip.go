@@ -0,0 +1,62 @@
+package export
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"ermete/internal/storage"
+)
+
+type Manifest struct {
+	ExportedAt time.Time           `json:"exported_at"`
+	Count      int                 `json:"count"`
+	Frames     []storage.FrameMeta `json:"frames"`
+}
+
+// Zip streams the frames matching q into a zip archive written to w, one
+// entry per frame under frames/, followed by a manifest.json with their
+// metadata. Frames are copied one at a time, so memory use does not depend
+// on the size of the export. Frames removed by retention while the export
+// runs are left out of the archive and the manifest.
+func Zip(w io.Writer, store *storage.FrameStore, q storage.FrameQuery) (Manifest, error) {
+	zw := zip.NewWriter(w)
+	manifest := Manifest{ExportedAt: time.Now().UTC(), Frames: []storage.FrameMeta{}}
+	err := store.WalkFrames(q, func(m storage.FrameMeta) error {
+		_, f, err := store.OpenFrame(m.FileName)
+		if errors.Is(err, storage.ErrFrameNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		// frames are already compressed images
+		entry, err := zw.CreateHeader(&zip.FileHeader{Name: "frames/" + m.FileName, Method: zip.Store, Modified: m.ReceivedAt})
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(entry, f); err != nil {
+			return fmt.Errorf("export %s: %w", m.FileName, err)
+		}
+		manifest.Frames = append(manifest.Frames, m)
+		return nil
+	})
+	if err != nil {
+		return manifest, err
+	}
+	manifest.Count = len(manifest.Frames)
+	entry, err := zw.CreateHeader(&zip.FileHeader{Name: "manifest.json", Method: zip.Deflate, Modified: manifest.ExportedAt})
+	if err != nil {
+		return manifest, err
+	}
+	enc := json.NewEncoder(entry)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(manifest); err != nil {
+		return manifest, err
+	}
+	return manifest, zw.Close()
+}
@@ -0,0 +1,102 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"testing"
+	"time"
+
+	"ermete/internal/observability"
+	"ermete/internal/storage"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func testStore(t *testing.T) *storage.FrameStore {
+	t.Helper()
+	store, err := storage.NewFrameStore(t.TempDir(), 10*time.Minute, 100, observability.NewMetrics(prometheus.NewRegistry()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	return store
+}
+
+func testPNG(t *testing.T, seed int) []byte {
+	t.Helper()
+	img := image.NewGray(image.Rect(0, 0, 4, 4))
+	img.SetGray(0, 0, color.Gray{Y: uint8(seed)})
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestZipStreamsFramesAndManifest(t *testing.T) {
+	store := testStore(t)
+	payloads := map[string][]byte{}
+	for i := 0; i < 3; i++ {
+		data := testPNG(t, i)
+		meta, err := store.SaveFrame(storage.FrameInput{FrameID: fmt.Sprintf("cam-%d", i)}, bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		payloads[meta.FileName] = data
+	}
+
+	var buf bytes.Buffer
+	manifest, err := Zip(&buf, store, storage.FrameQuery{FrameIDPrefix: "cam-"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Count != 3 {
+		t.Fatalf("expected 3 frames, got %d", manifest.Count)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zr.File) != 4 || zr.File[3].Name != "manifest.json" {
+		t.Fatalf("unexpected entries: %d", len(zr.File))
+	}
+	for _, f := range zr.File[:3] {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		if want := payloads[f.Name[len("frames/"):]]; !bytes.Equal(data, want) {
+			t.Fatalf("unexpected content for %s", f.Name)
+		}
+	}
+	rc, _ := zr.File[3].Open()
+	defer rc.Close()
+	var got Manifest
+	if err := json.NewDecoder(rc).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Count != 3 || got.Frames[0].FrameID != "cam-0" {
+		t.Fatalf("unexpected manifest: %+v", got)
+	}
+}
+
+func TestZipEmptySelection(t *testing.T) {
+	store := testStore(t)
+	var buf bytes.Buffer
+	manifest, err := Zip(&buf, store, storage.FrameQuery{Since: time.Now().Add(time.Hour)})
+	if err != nil || manifest.Count != 0 {
+		t.Fatalf("unexpected result %+v err=%v", manifest, err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil || len(zr.File) != 1 {
+		t.Fatalf("expected only the manifest, err=%v", err)
+	}
+}
@@ -19,6 +19,12 @@ import (
 )
 
 func testAPI(t *testing.T, cfg config.Config) http.Handler {
+	t.Helper()
+	h, _ := testAPIStore(t, cfg)
+	return h
+}
+
+func testAPIStore(t *testing.T, cfg config.Config) (http.Handler, *storage.FrameStore) {
 	t.Helper()
 	logger := zap.NewNop()
 	metrics := observability.NewMetrics(prometheus.NewRegistry())
@@ -31,7 +37,11 @@ func testAPI(t *testing.T, cfg config.Config) http.Handler {
 	if err != nil {
 		t.Fatal(err)
 	}
-	return NewRouter(cfg, logger, metrics, store, sessions, webrtcSvc)
+	uploads, err := storage.NewUploadStore(cfg.DataDir, store, cfg.MaxUploadBytes(), cfg.UploadExpiry, metrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewRouter(cfg, logger, metrics, store, uploads, sessions, webrtcSvc), store
 }
 
 func TestRequirePSKMiddleware(t *testing.T) {
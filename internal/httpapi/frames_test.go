@@ -0,0 +1,350 @@
+package httpapi
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"ermete/internal/config"
+	"ermete/internal/observability"
+	"ermete/internal/session"
+	"ermete/internal/storage"
+	wrtc "ermete/internal/webrtc"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+func testFramesConfig(t *testing.T) config.Config {
+	t.Helper()
+	return config.Config{MaxUploadMB: 1, DataDir: t.TempDir(), SessionPolicy: config.SessionPolicyRejectSecond, UploadRatePerSec: 100, UploadRateBurst: 100, WSRatePerSec: 100, WSRateBurst: 100, PSK: "secret", PSKHeader: "X-Ermete-PSK", WSAllowNoOrigin: true, RateLimitMaxEntries: 10000, RateLimitTTL: 30 * time.Minute, IdempotencyTTL: 10 * time.Minute, IdempotencyMax: 100}
+}
+
+func testPNG(t *testing.T, seed string) []byte {
+	t.Helper()
+	img := image.NewGray(image.Rect(0, 0, len(seed)+1, 2))
+	for i := 0; i < len(seed); i++ {
+		img.SetGray(i, 0, color.Gray{Y: seed[i]})
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func uploadFrame(t *testing.T, h http.Handler, frameID, contentType string, body []byte) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/v1/frames", bytes.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-Frame-Id", frameID)
+	req.Header.Set("X-Ermete-PSK", "secret")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("upload %s: expected 200, got %d body=%s", frameID, w.Code, w.Body.String())
+	}
+}
+
+func TestListFramesEndpoint(t *testing.T) {
+	h := testAPI(t, testFramesConfig(t))
+	uploadFrame(t, h, "cam-1", "image/png", testPNG(t, "a"))
+	uploadFrame(t, h, "cam-2", "image/png", testPNG(t, "b"))
+	uploadFrame(t, h, "other", "image/png", testPNG(t, "c"))
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/frames", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without psk, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/v1/frames?frame_id_prefix=cam-&limit=1", nil)
+	req.Header.Set("X-Ermete-PSK", "secret")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var page storage.FramePage
+	if err := json.NewDecoder(w.Body).Decode(&page); err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Frames) != 1 || page.Frames[0].FrameID != "cam-1" || page.NextCursor == "" {
+		t.Fatalf("unexpected first page: %+v", page)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/v1/frames?since=yesterday", nil)
+	req.Header.Set("X-Ermete-PSK", "secret")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for bad since, got %d", w.Code)
+	}
+}
+
+func TestDownloadFrameConditionalAndRange(t *testing.T) {
+	h := testAPI(t, testFramesConfig(t))
+	payload := testPNG(t, "0123456789")
+	uploadFrame(t, h, "cam-1", "image/png", payload)
+
+	get := func(path string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("X-Ermete-PSK", "secret")
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("/v1/frames/latest", nil)
+	if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), payload) {
+		t.Fatalf("unexpected latest response: %d %q", w.Code, w.Body.String())
+	}
+	if w.Header().Get("Content-Type") != "image/png" {
+		t.Fatalf("unexpected content type: %s", w.Header().Get("Content-Type"))
+	}
+	etag := w.Header().Get("ETag")
+	if len(etag) != 66 {
+		t.Fatalf("expected sha256 etag, got %q", etag)
+	}
+
+	var page storage.FramePage
+	_ = json.NewDecoder(get("/v1/frames", nil).Body).Decode(&page)
+	name := page.Frames[0].FileName
+
+	if w := get("/v1/frames/"+name, map[string]string{"If-None-Match": etag}); w.Code != http.StatusNotModified {
+		t.Fatalf("expected 304, got %d", w.Code)
+	}
+	w = get("/v1/frames/"+name, map[string]string{"Range": "bytes=2-4"})
+	if w.Code != http.StatusPartialContent || !bytes.Equal(w.Body.Bytes(), payload[2:5]) {
+		t.Fatalf("unexpected range response: %d %q", w.Code, w.Body.String())
+	}
+	if w := get("/v1/frames/..%2Fframes.index", nil); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for path outside frames dir, got %d", w.Code)
+	}
+	if w := get("/v1/frames/missing.png", nil); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown frame, got %d", w.Code)
+	}
+}
+
+func testRouterWithStore(t *testing.T, cfg config.Config, setup func(*storage.FrameStore)) http.Handler {
+	t.Helper()
+	logger := zap.NewNop()
+	metrics := observability.NewMetrics(prometheus.NewRegistry())
+	store, err := storage.OpenFrameStore(storage.Options{DataDir: cfg.DataDir, IdempotencyTTL: cfg.IdempotencyTTL, IdempotencyMax: cfg.IdempotencyMax, Layout: cfg.StorageLayout, Metrics: metrics})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	if setup != nil {
+		setup(store)
+	}
+	sessions := session.NewManager(cfg.SessionPolicy)
+	webrtcSvc, err := wrtc.NewService(cfg, logger, metrics, sessions, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uploads, err := storage.NewUploadStore(cfg.DataDir, store, cfg.MaxUploadBytes(), cfg.UploadExpiry, metrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewRouter(cfg, logger, metrics, store, uploads, sessions, webrtcSvc)
+}
+
+func TestDownloadPartitionedFrame(t *testing.T) {
+	cfg := testFramesConfig(t)
+	cfg.StorageLayout = config.StorageLayoutHourly
+	h := testRouterWithStore(t, cfg, nil)
+	payload := testPNG(t, "abc")
+	uploadFrame(t, h, "cam-1", "image/png", payload)
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/frames", nil)
+	req.Header.Set("X-Ermete-PSK", "secret")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	var page storage.FramePage
+	if err := json.NewDecoder(w.Body).Decode(&page); err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Frames) != 1 || !strings.Contains(page.Frames[0].FileName, "/") {
+		t.Fatalf("expected partitioned file name, got %+v", page.Frames)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/v1/frames/"+page.Frames[0].FileName, nil)
+	req.Header.Set("X-Ermete-PSK", "secret")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), payload) {
+		t.Fatalf("unexpected partitioned download: %d", w.Code)
+	}
+}
+
+func TestDownloadThumbnailVariant(t *testing.T) {
+	h := testRouterWithStore(t, testFramesConfig(t), func(s *storage.FrameStore) { s.Use(storage.NewThumbnailer(4, 75)) })
+	uploadFrame(t, h, "cam-1", "image/png", testPNG(t, "0123456789"))
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/frames/latest?variant=thumbnail", nil)
+	req.Header.Set("X-Ermete-PSK", "secret")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/jpeg" {
+		t.Fatalf("unexpected thumbnail response: %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	if !strings.HasSuffix(w.Header().Get("ETag"), `-thumb"`) {
+		t.Fatalf("unexpected thumbnail etag: %s", w.Header().Get("ETag"))
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/v1/frames/latest?variant=poster", nil)
+	req.Header.Set("X-Ermete-PSK", "secret")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for unknown variant, got %d", w.Code)
+	}
+}
+
+func TestExportFramesZip(t *testing.T) {
+	h := testAPI(t, testFramesConfig(t))
+	uploadFrame(t, h, "cam-1", "image/png", testPNG(t, "a"))
+	uploadFrame(t, h, "other", "image/png", testPNG(t, "b"))
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/frames/export?frame_id_prefix=cam-&since="+time.Now().Add(-time.Hour).UTC().Format(time.RFC3339), nil)
+	req.Header.Set("X-Ermete-PSK", "secret")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("unexpected response %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zr.File) != 2 || !strings.HasPrefix(zr.File[0].Name, "frames/cam-1_") || zr.File[1].Name != "manifest.json" {
+		t.Fatalf("unexpected zip entries: %d", len(zr.File))
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/v1/frames/export?until=tomorrow", nil)
+	req.Header.Set("X-Ermete-PSK", "secret")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for bad until, got %d", w.Code)
+	}
+}
+
+func TestTimelapseEndpoint(t *testing.T) {
+	cfg := testFramesConfig(t)
+	h := testAPI(t, cfg)
+	req := httptest.NewRequest(http.MethodGet, "/v1/frames/timelapse", nil)
+	req.Header.Set("X-Ermete-PSK", "secret")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 without frames, got %d", w.Code)
+	}
+
+	uploadFrame(t, h, "cam-1", "image/png", testPNG(t, "a"))
+	uploadFrame(t, h, "cam-1", "image/png", testPNG(t, "b"))
+	req = httptest.NewRequest(http.MethodGet, "/v1/frames/timelapse?fps=2&overlay=true", nil)
+	req.Header.Set("X-Ermete-PSK", "secret")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	body := w.Body.Bytes()
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "video/x-msvideo" || len(body) < 12 || string(body[8:12]) != "AVI " {
+		t.Fatalf("unexpected response %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	// transcoded frames are spooled under DATA_DIR and removed afterwards
+	if entries, err := os.ReadDir(filepath.Join(cfg.DataDir, "tmp")); err != nil || len(entries) != 0 {
+		t.Fatalf("expected an empty spool dir under DATA_DIR, got %v err=%v", entries, err)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/v1/frames/timelapse?fps=0", nil)
+	req.Header.Set("X-Ermete-PSK", "secret")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for bad fps, got %d", w.Code)
+	}
+}
+
+type fakeSession string
+
+func (s fakeSession) ID() string { return string(s) }
+func (fakeSession) Close(string) {}
+
+func TestFramesCarrySessionIDs(t *testing.T) {
+	cfg := testFramesConfig(t)
+	metrics := observability.NewMetrics(prometheus.NewRegistry())
+	store, err := storage.NewFrameStore(cfg.DataDir, cfg.IdempotencyTTL, cfg.IdempotencyMax, metrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions := session.NewManager(cfg.SessionPolicy)
+	webrtcSvc, err := wrtc.NewService(cfg, zap.NewNop(), metrics, sessions, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uploads, err := storage.NewUploadStore(cfg.DataDir, store, cfg.MaxUploadBytes(), cfg.UploadExpiry, metrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewRouter(cfg, zap.NewNop(), metrics, store, uploads, sessions, webrtcSvc)
+
+	uploadFrame(t, h, "before", "image/png", testPNG(t, "a"))
+	if err := sessions.Acquire(fakeSession("sess-1")); err != nil {
+		t.Fatal(err)
+	}
+	uploadFrame(t, h, "during", "image/png", testPNG(t, "b"))
+	sessions.Release("sess-1")
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/frames", bytes.NewReader(testPNG(t, "c")))
+	req.Header.Set("Content-Type", "image/png")
+	req.Header.Set("X-Frame-Id", "tagged")
+	req.Header.Set("X-Session-Id", "call-42")
+	req.Header.Set("X-Ermete-PSK", "secret")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", w.Code, w.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/v1/frames", bytes.NewReader(testPNG(t, "d")))
+	req.Header.Set("Content-Type", "image/png")
+	req.Header.Set("X-Session-Id", "../bad")
+	req.Header.Set("X-Ermete-PSK", "secret")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for invalid session id, got %d", w.Code)
+	}
+
+	for id, want := range map[string]string{"sess-1": "during", "call-42": "tagged"} {
+		req = httptest.NewRequest(http.MethodGet, "/v1/sessions/"+id+"/frames", nil)
+		req.Header.Set("X-Ermete-PSK", "secret")
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", w.Code)
+		}
+		var page storage.FramePage
+		if err := json.NewDecoder(w.Body).Decode(&page); err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Frames) != 1 || page.Frames[0].FrameID != want {
+			t.Fatalf("session %s: unexpected frames %+v", id, page.Frames)
+		}
+	}
+}
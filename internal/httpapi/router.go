@@ -2,18 +2,27 @@ package httpapi
 
 import (
 	"context"
+	"crypto/md5"
+	"crypto/sha256"
 	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"mime/multipart"
+	"math"
 	"net"
 	"net/http"
+	"net/textproto"
+	"path"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"ermete/internal/config"
+	"ermete/internal/export"
 	"ermete/internal/observability"
 	"ermete/internal/session"
 	"ermete/internal/storage"
@@ -28,23 +37,26 @@ import (
 	"golang.org/x/time/rate"
 )
 
+const defaultBatchMaxFrames = 100
+
 type API struct {
 	cfg      config.Config
 	logger   *zap.Logger
 	metrics  *observability.Metrics
 	store    *storage.FrameStore
+	uploads  *storage.UploadStore
 	sessions *session.Manager
 	webrtc   *wrtc.Service
 	started  time.Time
 	limits   *Limiter
 }
 
-func NewRouter(cfg config.Config, logger *zap.Logger, metrics *observability.Metrics, store *storage.FrameStore, sessions *session.Manager, webrtc *wrtc.Service) http.Handler {
-	a := &API{cfg: cfg, logger: logger, metrics: metrics, store: store, sessions: sessions, webrtc: webrtc, started: time.Now().UTC(), limits: NewLimiter(cfg.RateLimitTTL, cfg.RateLimitMaxEntries, metrics, logger)}
+func NewRouter(cfg config.Config, logger *zap.Logger, metrics *observability.Metrics, store *storage.FrameStore, uploads *storage.UploadStore, sessions *session.Manager, webrtc *wrtc.Service) http.Handler {
+	a := &API{cfg: cfg, logger: logger, metrics: metrics, store: store, uploads: uploads, sessions: sessions, webrtc: webrtc, started: time.Now().UTC(), limits: NewLimiter(cfg.RateLimitTTL, cfg.RateLimitMaxEntries, metrics, logger)}
 	r := chi.NewRouter()
 	r.Use(chimw.RequestID, chimw.RealIP, chimw.Recoverer, a.requestLogger)
 	if len(cfg.CORSAllowedOrigins) > 0 {
-		r.Use(cors.Handler(cors.Options{AllowedOrigins: cfg.CORSAllowedOrigins, AllowedMethods: []string{"GET", "POST", "OPTIONS"}, AllowedHeaders: []string{"*"}}))
+		r.Use(cors.Handler(cors.Options{AllowedOrigins: cfg.CORSAllowedOrigins, AllowedMethods: []string{"GET", "HEAD", "POST", "PATCH", "DELETE", "OPTIONS"}, AllowedHeaders: []string{"*"}, ExposedHeaders: []string{"Location", "Upload-Offset", "Upload-Length"}}))
 	}
 
 	r.Get("/healthz", func(w http.ResponseWriter, _ *http.Request) {
@@ -55,13 +67,29 @@ func NewRouter(cfg config.Config, logger *zap.Logger, metrics *observability.Met
 	r.Handle("/metrics", promhttp.Handler())
 
 	r.Group(func(r chi.Router) {
-		r.Use(a.rateLimitMiddleware(cfg.UploadRatePerSec, cfg.UploadRateBurst), a.requirePSK)
+		r.Use(a.rateLimitMiddleware(cfg.UploadRatePerSec, cfg.UploadRateBurst), a.requirePSK, a.requireStorage)
 		r.Post("/v1/frames", a.handleFrameUpload)
+		r.Post("/v1/frames/batch", a.handleBatchUpload)
+		r.Post("/v1/uploads", a.handleCreateUpload)
+		r.Patch("/v1/uploads/{id}", a.handlePatchUpload)
+		r.Post("/v1/uploads/{id}/finalize", a.handleFinalizeUpload)
 	})
 	r.Group(func(r chi.Router) {
 		r.Use(a.rateLimitMiddleware(cfg.WSRatePerSec, cfg.WSRateBurst), a.requirePSK)
 		r.Get("/v1/ws", a.handleWS)
 	})
+	r.Group(func(r chi.Router) {
+		r.Use(a.requirePSK)
+		r.Get("/v1/frames", a.handleListFrames)
+		r.Get("/v1/frames/latest", a.handleLatestFrame)
+		r.Get("/v1/frames/export", a.handleExportFrames)
+		r.Get("/v1/frames/timelapse", a.handleTimelapse)
+		r.Get("/v1/frames/*", a.handleDownloadFrame)
+		r.Get("/v1/sessions/{id}/frames", a.handleListSessionFrames)
+		r.Head("/v1/uploads/{id}", a.handleGetUpload)
+		r.Get("/v1/uploads/{id}", a.handleGetUpload)
+		r.Delete("/v1/uploads/{id}", a.handleDeleteUpload)
+	})
 	return r
 }
 
@@ -109,43 +137,535 @@ func (a *API) handleWS(w http.ResponseWriter, r *http.Request) {
 
 func (a *API) handleFrameUpload(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	maxBytes := a.cfg.MaxUploadBytes()
-	contentType := r.Header.Get("Content-Type")
-	frameID := r.Header.Get("X-Frame-Id")
-	timestamp := r.Header.Get("X-Timestamp")
-	idem := r.Header.Get("X-Idempotency-Key")
+	in := storage.FrameInput{
+		FrameID:         r.Header.Get("X-Frame-Id"),
+		Timestamp:       r.Header.Get("X-Timestamp"),
+		IdempotencyKey:  r.Header.Get("X-Idempotency-Key"),
+		SessionID:       a.sessions.Snapshot().SessionID,
+		ClientSessionID: r.Header.Get("X-Session-Id"),
+		DeviceID:        r.Header.Get("X-Device-Id"),
+	}
 
-	var payload []byte
-	var err error
-	if strings.HasPrefix(contentType, "multipart/form-data") {
-		payload, contentType, err = readMultipartPayload(r, maxBytes)
-	} else {
-		payload, err = storage.ReadAllLimited(r.Body, maxBytes)
+	digests, err := parseDigestHeaders(r.Header)
+	if err != nil {
+		a.metrics.FrameUploadErrors.Inc()
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
 	}
+	body, contentType, err := frameBody(r, a.cfg.MaxUploadBytes())
 	if err != nil {
 		a.metrics.FrameUploadErrors.Inc()
-		if strings.Contains(strings.ToLower(err.Error()), "too large") {
-			writeJSON(w, http.StatusRequestEntityTooLarge, map[string]string{"error": "payload too large"})
-			return
-		}
 		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid payload: %v", err)})
 		return
 	}
+	in.ContentType = contentType
+	if !digests.Empty() {
+		body = storage.VerifyReader(body, digests)
+	}
 
-	meta, err := a.store.SaveFrame(frameID, timestamp, idem, contentType, payload)
+	meta, err := a.store.SaveFrame(in, body)
 	if err != nil {
 		a.metrics.FrameUploadErrors.Inc()
-		http.Error(w, "failed to save frame", http.StatusInternalServerError)
+		code, resp := a.saveFrameError(r, err)
+		if code == http.StatusInternalServerError {
+			http.Error(w, "failed to save frame", code)
+			return
+		}
+		if code == http.StatusInsufficientStorage {
+			a.setRetryAfter(w)
+		}
+		writeJSON(w, code, resp)
 		return
 	}
 	a.metrics.FramesUploadedTotal.Inc()
-	a.metrics.FrameUploadBytesTotal.Add(float64(len(payload)))
+	a.metrics.FrameUploadBytesTotal.Add(float64(meta.Size))
 	a.sessions.Touch()
 
 	resp := map[string]any{"status": "ok", "duplicate": meta.Duplicate, "frame": meta, "request_id": chimw.GetReqID(ctx)}
+	if !digests.Empty() {
+		resp["verified"] = verifiedDigests(digests)
+	}
 	writeJSON(w, http.StatusOK, resp)
 }
 
+func (a *API) saveFrameError(r *http.Request, err error) (int, map[string]string) {
+	var conflict *storage.IdempotencyConflictError
+	var mismatch *storage.ChecksumMismatchError
+	switch {
+	case errors.As(err, &conflict):
+		a.logger.Warn("idempotency key conflict", zap.String("ip", clientIP(r)), zap.String("idempotency_key", conflict.Key), zap.String("stored_sha256", conflict.StoredSHA256), zap.String("received_sha256", conflict.ReceivedSHA256))
+		return http.StatusConflict, map[string]string{"error": "idempotency key reused with different payload", "idempotency_key": conflict.Key, "stored_sha256": conflict.StoredSHA256, "received_sha256": conflict.ReceivedSHA256}
+	case errors.Is(err, storage.ErrPayloadTooLarge):
+		return http.StatusRequestEntityTooLarge, map[string]string{"error": "payload too large"}
+	case errors.As(err, &mismatch):
+		a.logger.Warn("checksum mismatch", zap.String("ip", clientIP(r)), zap.String("algorithm", mismatch.Algorithm), zap.String("expected", mismatch.Expected), zap.String("actual", mismatch.Actual))
+		return http.StatusUnprocessableEntity, map[string]string{"error": "checksum mismatch", "algorithm": mismatch.Algorithm, "expected": mismatch.Expected, "actual": mismatch.Actual}
+	case errors.Is(err, storage.ErrUnsupportedMediaType):
+		return http.StatusUnsupportedMediaType, map[string]string{"error": err.Error()}
+	case errors.Is(err, storage.ErrInvalidPayload):
+		return http.StatusBadRequest, map[string]string{"error": err.Error()}
+	case errors.Is(err, storage.ErrInsufficientStorage):
+		a.logger.Warn("frame rejected", zap.String("ip", clientIP(r)), zap.Error(err))
+		return http.StatusInsufficientStorage, map[string]string{"error": "insufficient storage"}
+	default:
+		a.logger.Error("save frame failed", zap.Error(err))
+		return http.StatusInternalServerError, map[string]string{"error": "failed to save frame"}
+	}
+}
+
+// handleBatchUpload saves every "file" part of a multipart body. The
+// frame_id, timestamp and idempotency_key fields apply to the next file
+// part; each item is saved independently and reported in results, indexed
+// by the position of its file part. An invalid field fails the file part it
+// precedes.
+func (a *API) handleBatchUpload(w http.ResponseWriter, r *http.Request) {
+	maxFrames := a.cfg.BatchMaxFrames
+	if maxFrames <= 0 {
+		maxFrames = defaultBatchMaxFrames
+	}
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "batch upload requires multipart/form-data"})
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, a.cfg.MaxUploadBytes()*int64(maxFrames+1))
+	mr, err := r.MultipartReader()
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid payload: %v", err)})
+		return
+	}
+
+	sessionID, clientSessionID, deviceID := a.sessions.Snapshot().SessionID, r.Header.Get("X-Session-Id"), r.Header.Get("X-Device-Id")
+	results := []map[string]any{}
+	fields := map[string]string{}
+	var fieldErr map[string]string
+	files, saved, failed := 0, 0, 0
+	fail := func(code int, resp map[string]string) {
+		item := map[string]any{"index": files, "status": code}
+		for k, v := range resp {
+			item[k] = v
+		}
+		results = append(results, item)
+		failed++
+		a.metrics.FrameUploadErrors.Inc()
+	}
+parts:
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// the rest of the body cannot be parsed, but frames already
+			// saved stay saved
+			fail(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid payload: %v", err)})
+			break parts
+		}
+		name := part.FormName()
+		switch name {
+		case "frame_id", "timestamp", "idempotency_key":
+			v, err := storage.ReadAllLimited(part, 1024)
+			if err != nil {
+				if fieldErr == nil {
+					fieldErr = map[string]string{"error": fmt.Sprintf("invalid %s field: %v", name, err)}
+				}
+				_ = part.Close()
+				continue
+			}
+			fields[name] = strings.TrimSpace(string(v))
+		case "file":
+			if files >= maxFrames {
+				fail(http.StatusRequestEntityTooLarge, map[string]string{"error": fmt.Sprintf("batch exceeds %d frames", maxFrames)})
+				_ = part.Close()
+				break parts
+			}
+			in := storage.FrameInput{FrameID: fields["frame_id"], Timestamp: fields["timestamp"], IdempotencyKey: fields["idempotency_key"], ContentType: multipartContentType(part.Header), SessionID: sessionID, ClientSessionID: clientSessionID, DeviceID: deviceID}
+			fields = map[string]string{}
+			var meta storage.FrameMeta
+			code, resp := 0, fieldErr
+			fieldErr = nil
+			if resp != nil {
+				code = http.StatusBadRequest
+			} else if meta, err = a.store.SaveFrame(in, storage.LimitReader(part, a.cfg.MaxUploadBytes())); err != nil {
+				code, resp = a.saveFrameError(r, err)
+			}
+			if resp != nil {
+				if in.FrameID != "" {
+					resp["frame_id"] = in.FrameID
+				}
+				if in.IdempotencyKey != "" {
+					resp["idempotency_key"] = in.IdempotencyKey
+				}
+				fail(code, resp)
+				files++
+				_ = part.Close()
+				continue
+			}
+			a.metrics.FramesUploadedTotal.Inc()
+			a.metrics.FrameUploadBytesTotal.Add(float64(meta.Size))
+			results = append(results, map[string]any{"index": files, "status": http.StatusOK, "duplicate": meta.Duplicate, "frame": meta})
+			files++
+			saved++
+		}
+		_ = part.Close()
+	}
+	if fieldErr != nil {
+		fieldErr["error"] += " (no file part follows)"
+		fail(http.StatusBadRequest, fieldErr)
+	}
+	if len(results) == 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "no file parts in batch"})
+		return
+	}
+	if saved > 0 {
+		a.sessions.Touch()
+	}
+	code, status := http.StatusOK, "ok"
+	switch {
+	case failed > 0 && saved > 0:
+		code, status = http.StatusMultiStatus, "partial"
+	case failed > 0:
+		code, status = http.StatusMultiStatus, "failed"
+	}
+	writeJSON(w, code, map[string]any{"status": status, "saved": saved, "failed": failed, "results": results, "request_id": chimw.GetReqID(r.Context())})
+}
+
+func (a *API) handleCreateUpload(w http.ResponseWriter, r *http.Request) {
+	var length int64
+	if raw := r.Header.Get("Upload-Length"); raw != "" {
+		var err error
+		if length, err = strconv.ParseInt(raw, 10, 64); err != nil || length <= 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid Upload-Length: %s", raw)})
+			return
+		}
+	}
+	in := storage.FrameInput{
+		FrameID:         r.Header.Get("X-Frame-Id"),
+		Timestamp:       r.Header.Get("X-Timestamp"),
+		IdempotencyKey:  r.Header.Get("X-Idempotency-Key"),
+		ContentType:     r.Header.Get("X-Upload-Content-Type"),
+		SessionID:       a.sessions.Snapshot().SessionID,
+		ClientSessionID: r.Header.Get("X-Session-Id"),
+		DeviceID:        r.Header.Get("X-Device-Id"),
+	}
+	up, err := a.uploads.Create(in, length)
+	if errors.Is(err, storage.ErrPayloadTooLarge) {
+		writeJSON(w, http.StatusRequestEntityTooLarge, map[string]string{"error": "payload too large"})
+		return
+	}
+	if errors.Is(err, storage.ErrInvalidPayload) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		a.logger.Error("create upload failed", zap.Error(err))
+		http.Error(w, "failed to create upload", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Location", "/v1/uploads/"+up.ID)
+	setUploadHeaders(w, up)
+	writeJSON(w, http.StatusCreated, up)
+}
+
+func (a *API) handleGetUpload(w http.ResponseWriter, r *http.Request) {
+	up, err := a.uploads.Get(chi.URLParam(r, "id"))
+	if err != nil {
+		a.writeUploadError(w, r, err)
+		return
+	}
+	setUploadHeaders(w, up)
+	w.Header().Set("Cache-Control", "no-store")
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	writeJSON(w, http.StatusOK, up)
+}
+
+func (a *API) handlePatchUpload(w http.ResponseWriter, r *http.Request) {
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "missing or invalid Upload-Offset"})
+		return
+	}
+	up, err := a.uploads.Append(chi.URLParam(r, "id"), offset, r.Body)
+	if err != nil {
+		if up.ID != "" {
+			setUploadHeaders(w, up)
+		}
+		a.writeUploadError(w, r, err)
+		return
+	}
+	setUploadHeaders(w, up)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *API) handleFinalizeUpload(w http.ResponseWriter, r *http.Request) {
+	meta, err := a.uploads.Finalize(chi.URLParam(r, "id"))
+	if err != nil {
+		a.metrics.FrameUploadErrors.Inc()
+		a.writeUploadError(w, r, err)
+		return
+	}
+	a.metrics.FramesUploadedTotal.Inc()
+	a.metrics.FrameUploadBytesTotal.Add(float64(meta.Size))
+	a.sessions.Touch()
+	writeJSON(w, http.StatusOK, map[string]any{"status": "ok", "duplicate": meta.Duplicate, "frame": meta, "request_id": chimw.GetReqID(r.Context())})
+}
+
+func (a *API) handleDeleteUpload(w http.ResponseWriter, r *http.Request) {
+	if err := a.uploads.Abort(chi.URLParam(r, "id")); err != nil {
+		a.writeUploadError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *API) writeUploadError(w http.ResponseWriter, r *http.Request, err error) {
+	var mismatch *storage.UploadOffsetError
+	switch {
+	case errors.Is(err, storage.ErrUploadNotFound):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+	case errors.As(err, &mismatch):
+		writeJSON(w, http.StatusConflict, map[string]any{"error": "upload offset mismatch", "offset": mismatch.Offset})
+	case errors.Is(err, storage.ErrUploadIncomplete), errors.Is(err, storage.ErrUploadCompleted), errors.Is(err, storage.ErrUploadBusy):
+		writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+	default:
+		code, resp := a.saveFrameError(r, err)
+		if code == http.StatusInsufficientStorage {
+			a.setRetryAfter(w)
+		}
+		writeJSON(w, code, resp)
+	}
+}
+
+func setUploadHeaders(w http.ResponseWriter, up storage.Upload) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(up.Offset, 10))
+	if up.Length > 0 {
+		w.Header().Set("Upload-Length", strconv.FormatInt(up.Length, 10))
+	}
+}
+
+func (a *API) handleListFrames(w http.ResponseWriter, r *http.Request) {
+	q, err := parseFrameQuery(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	a.listFrames(w, q)
+}
+
+// handleListSessionFrames lists the frames uploaded while the given WebRTC
+// session was active or tagged with it through X-Session-Id.
+func (a *API) handleListSessionFrames(w http.ResponseWriter, r *http.Request) {
+	q, err := parseFrameQuery(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	q.SessionID = chi.URLParam(r, "id")
+	a.listFrames(w, q)
+}
+
+func (a *API) listFrames(w http.ResponseWriter, q storage.FrameQuery) {
+	page, err := a.store.ListFrames(q)
+	if errors.Is(err, storage.ErrInvalidCursor) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to list frames", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+func (a *API) handleExportFrames(w http.ResponseWriter, r *http.Request) {
+	q, err := parseFrameQuery(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	q.Cursor = ""
+	if q.TimeField == "" {
+		// exports follow capture order, like a recording
+		q.TimeField = storage.TimeFieldTimestamp
+	}
+	// an export can take longer than WRITE_TIMEOUT; the recorder used in
+	// tests does not support deadlines
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="frames-%s.zip"`, time.Now().UTC().Format("20060102T150405Z")))
+	w.Header().Set("Cache-Control", "no-store")
+	manifest, err := export.Zip(w, a.store, q)
+	if err != nil {
+		// the status line is already sent: the client sees a truncated zip
+		a.logger.Error("frame export failed", zap.Int("frames", len(manifest.Frames)), zap.Error(err))
+		return
+	}
+	a.logger.Info("frames exported", zap.Int("frames", manifest.Count), zap.String("ip", clientIP(r)))
+}
+
+func (a *API) handleTimelapse(w http.ResponseWriter, r *http.Request) {
+	q, err := parseFrameQuery(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	opts, err := parseTimelapseOptions(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	opts.TempDir = export.TimelapseTempDir(a.cfg.DataDir)
+	q.Cursor = ""
+	if q.TimeField == "" {
+		q.TimeField = storage.TimeFieldTimestamp
+	}
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	// headers are only sent once Timelapse starts writing, after every
+	// frame has been transcoded
+	hw := &lazyHeaderWriter{w: w, header: func(h http.Header) {
+		h.Set("Content-Type", "video/x-msvideo")
+		h.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="timelapse-%s.avi"`, time.Now().UTC().Format("20060102T150405Z")))
+		h.Set("Cache-Control", "no-store")
+	}}
+	res, err := export.Timelapse(hw, a.store, q, opts)
+	switch {
+	case errors.Is(err, export.ErrNoFrames):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+	case err != nil && !hw.wrote:
+		a.logger.Error("timelapse failed", zap.Error(err))
+		http.Error(w, "failed to build timelapse", http.StatusInternalServerError)
+	case err != nil:
+		a.logger.Error("timelapse failed", zap.Int("frames", res.Frames), zap.Error(err))
+	default:
+		a.logger.Info("timelapse exported", zap.Int("frames", res.Frames), zap.Int("skipped", res.Skipped), zap.String("ip", clientIP(r)))
+	}
+}
+
+func parseTimelapseOptions(r *http.Request) (export.TimelapseOptions, error) {
+	v := r.URL.Query()
+	opts := export.TimelapseOptions{FPS: export.DefaultTimelapseFPS, Quality: export.DefaultTimelapseQuality}
+	if raw := v.Get("fps"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > export.MaxTimelapseFPS {
+			return opts, fmt.Errorf("invalid fps: %s", raw)
+		}
+		opts.FPS = n
+	}
+	if raw := v.Get("quality"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > 100 {
+			return opts, fmt.Errorf("invalid quality: %s", raw)
+		}
+		opts.Quality = n
+	}
+	if raw := v.Get("overlay"); raw != "" {
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return opts, fmt.Errorf("invalid overlay: %s", raw)
+		}
+		opts.Overlay = b
+	}
+	return opts, nil
+}
+
+type lazyHeaderWriter struct {
+	w      http.ResponseWriter
+	header func(http.Header)
+	wrote  bool
+}
+
+func (l *lazyHeaderWriter) Write(p []byte) (int, error) {
+	if !l.wrote {
+		l.wrote = true
+		l.header(l.w.Header())
+	}
+	return l.w.Write(p)
+}
+
+func (a *API) handleLatestFrame(w http.ResponseWriter, r *http.Request) {
+	last, count := a.store.LastMeta()
+	if count == 0 {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "no frames stored"})
+		return
+	}
+	a.serveFrame(w, r, last.FileName)
+}
+
+func (a *API) handleDownloadFrame(w http.ResponseWriter, r *http.Request) {
+	a.serveFrame(w, r, chi.URLParam(r, "*"))
+}
+
+func (a *API) serveFrame(w http.ResponseWriter, r *http.Request, fileName string) {
+	open, contentType, etagSuffix := a.store.OpenFrame, "", ""
+	switch variant := r.URL.Query().Get("variant"); variant {
+	case "", "original":
+	case "thumbnail":
+		open, contentType, etagSuffix = a.store.OpenThumbnail, "image/jpeg", "-thumb"
+	default:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid variant: %s", variant)})
+		return
+	}
+	meta, f, err := open(fileName)
+	if errors.Is(err, storage.ErrFrameNotFound) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "frame not found"})
+		return
+	}
+	if err != nil {
+		a.logger.Error("open frame failed", zap.String("file_name", fileName), zap.Error(err))
+		http.Error(w, "failed to open frame", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	if contentType == "" {
+		contentType = meta.ContentType
+	}
+	if contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.Header().Set("ETag", `"`+meta.SHA256+etagSuffix+`"`)
+	w.Header().Set("Cache-Control", "private, max-age=0, must-revalidate")
+	http.ServeContent(w, r, path.Base(meta.FileName), meta.ReceivedAt, f)
+}
+
+func parseFrameQuery(r *http.Request) (storage.FrameQuery, error) {
+	v := r.URL.Query()
+	q := storage.FrameQuery{
+		TimeField:     storage.TimeField(v.Get("time_field")),
+		FrameIDPrefix: v.Get("frame_id_prefix"),
+		ContentType:   v.Get("content_type"),
+		SessionID:     v.Get("session_id"),
+		Partition:     v.Get("partition"),
+		Cursor:        v.Get("cursor"),
+	}
+	switch q.TimeField {
+	case "", storage.TimeFieldReceived, storage.TimeFieldTimestamp:
+	default:
+		return q, fmt.Errorf("invalid time_field: %s", q.TimeField)
+	}
+	var err error
+	if q.Since, err = parseTimeParam(v.Get("since")); err != nil {
+		return q, fmt.Errorf("invalid since: %w", err)
+	}
+	if q.Until, err = parseTimeParam(v.Get("until")); err != nil {
+		return q, fmt.Errorf("invalid until: %w", err)
+	}
+	if raw := v.Get("limit"); raw != "" {
+		if q.Limit, err = strconv.Atoi(raw); err != nil || q.Limit <= 0 {
+			return q, fmt.Errorf("invalid limit: %s", raw)
+		}
+	}
+	return q, nil
+}
+
+func parseTimeParam(raw string) (time.Time, error) {
+	if raw == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339Nano, raw)
+}
+
 func (a *API) requirePSK(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		provided := r.Header.Get(a.cfg.PSKHeader)
@@ -166,25 +686,88 @@ func (a *API) requirePSK(next http.Handler) http.Handler {
 	})
 }
 
-func readMultipartPayload(r *http.Request, maxBytes int64) ([]byte, string, error) {
-	if err := r.ParseMultipartForm(maxBytes); err != nil {
-		return nil, "", err
+// requireStorage answers 507 before the body is read while the storage
+// health probe reports the disk full or read-only.
+func (a *API) requireStorage(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if st := a.store.Health(); !st.OK() {
+			a.metrics.FrameUploadErrors.Inc()
+			a.metrics.StorageRejectedTotal.Inc()
+			a.setRetryAfter(w)
+			writeJSON(w, http.StatusInsufficientStorage, map[string]any{"error": "insufficient storage", "writable": st.Writable, "free_bytes": st.FreeBytes})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (a *API) setRetryAfter(w http.ResponseWriter) {
+	secs := int(math.Ceil(a.store.RetryAfter().Seconds()))
+	if secs < 1 {
+		secs = 1
 	}
-	file, header, err := r.FormFile("file")
-	if err != nil {
-		return nil, "", err
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
+}
+
+func frameBody(r *http.Request, maxBytes int64) (io.Reader, string, error) {
+	contentType := r.Header.Get("Content-Type")
+	if !strings.HasPrefix(contentType, "multipart/form-data") {
+		return storage.LimitReader(r.Body, maxBytes), contentType, nil
 	}
-	defer file.Close()
-	payload, err := storage.ReadAllLimited(file, maxBytes)
+	mr, err := r.MultipartReader()
 	if err != nil {
 		return nil, "", err
 	}
-	return payload, detectMultipartContentType(header), nil
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil, "", http.ErrMissingFile
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		if part.FormName() == "file" {
+			return storage.LimitReader(part, maxBytes), multipartContentType(part.Header), nil
+		}
+		_ = part.Close()
+	}
+}
+
+// parseDigestHeaders reads the optional X-Content-SHA256 (hex) and
+// Content-MD5 (base64, RFC 1864) headers.
+func parseDigestHeaders(h http.Header) (storage.ExpectedDigests, error) {
+	var d storage.ExpectedDigests
+	if raw := strings.TrimSpace(h.Get("X-Content-SHA256")); raw != "" {
+		sum, err := hex.DecodeString(raw)
+		if err != nil || len(sum) != sha256.Size {
+			return d, fmt.Errorf("invalid X-Content-SHA256: expected %d hex characters", 2*sha256.Size)
+		}
+		d.SHA256 = sum
+	}
+	if raw := strings.TrimSpace(h.Get("Content-MD5")); raw != "" {
+		sum, err := base64.StdEncoding.DecodeString(raw)
+		if err != nil || len(sum) != md5.Size {
+			return d, errors.New("invalid Content-MD5: expected the base64 MD5 digest")
+		}
+		d.MD5 = sum
+	}
+	return d, nil
+}
+
+func verifiedDigests(d storage.ExpectedDigests) map[string]string {
+	out := map[string]string{}
+	if d.SHA256 != nil {
+		out["sha256"] = hex.EncodeToString(d.SHA256)
+	}
+	if d.MD5 != nil {
+		out["md5"] = base64.StdEncoding.EncodeToString(d.MD5)
+	}
+	return out
 }
 
-func detectMultipartContentType(h *multipart.FileHeader) string {
-	if h.Header.Get("Content-Type") != "" {
-		return h.Header.Get("Content-Type")
+func multipartContentType(h textproto.MIMEHeader) string {
+	if h.Get("Content-Type") != "" {
+		return h.Get("Content-Type")
 	}
 	return "application/octet-stream"
 }
@@ -2,9 +2,18 @@ package httpapi
 
 import (
 	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"io"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/textproto"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -25,7 +34,8 @@ func TestUploadLimitAndSafeName(t *testing.T) {
 	store, _ := storage.NewFrameStore(cfg.DataDir, 10*time.Minute, 100, metrics)
 	sessions := session.NewManager(cfg.SessionPolicy)
 	webrtcSvc, _ := wrtc.NewService(cfg, logger, metrics, sessions, store)
-	h := NewRouter(cfg, logger, metrics, store, sessions, webrtcSvc)
+	uploads, _ := storage.NewUploadStore(cfg.DataDir, store, cfg.MaxUploadBytes(), cfg.UploadExpiry, metrics)
+	h := NewRouter(cfg, logger, metrics, store, uploads, sessions, webrtcSvc)
 
 	big := bytes.Repeat([]byte("a"), int(cfg.MaxUploadBytes()+1))
 	req := httptest.NewRequest(http.MethodPost, "/v1/frames", bytes.NewReader(big))
@@ -37,7 +47,7 @@ func TestUploadLimitAndSafeName(t *testing.T) {
 		t.Fatalf("expected 413 for large payload, got %d", w.Code)
 	}
 
-	small := []byte("ok")
+	small := testPNG(t, "ok")
 	req2 := httptest.NewRequest(http.MethodPost, "/v1/frames", bytes.NewReader(small))
 	req2.Header.Set("Content-Type", "image/png")
 	req2.Header.Set("X-Frame-Id", "../bad:id")
@@ -49,7 +59,412 @@ func TestUploadLimitAndSafeName(t *testing.T) {
 		t.Fatalf("expected 200, got %d body=%s", w2.Code, string(body))
 	}
 	last, _ := store.LastMeta()
+	if last.Format != "png" {
+		t.Fatalf("unexpected detected format: %s", last.Format)
+	}
 	if last.FileName == "" || last.FileName[:1] == "/" {
 		t.Fatalf("unexpected filename: %s", last.FileName)
 	}
 }
+
+func TestUploadIdempotencyConflict(t *testing.T) {
+	cfg := config.Config{MaxUploadMB: 1, DataDir: t.TempDir(), SessionPolicy: config.SessionPolicyRejectSecond, UploadRatePerSec: 100, UploadRateBurst: 100, WSRatePerSec: 100, WSRateBurst: 100, PSK: "secret", PSKHeader: "X-Ermete-PSK", WSAllowNoOrigin: true, RateLimitMaxEntries: 10000, RateLimitTTL: 30 * time.Minute, IdempotencyTTL: 10 * time.Minute, IdempotencyMax: 100}
+	h := testAPI(t, cfg)
+
+	upload := func(seed string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/frames", bytes.NewReader(testPNG(t, seed)))
+		req.Header.Set("Content-Type", "image/png")
+		req.Header.Set("X-Idempotency-Key", "same-key")
+		req.Header.Set("X-Ermete-PSK", "secret")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+	if w := upload("first"); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	if w := upload("first"); w.Code != http.StatusOK {
+		t.Fatalf("expected 200 for identical retry, got %d", w.Code)
+	}
+	w := upload("second")
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for reused key, got %d", w.Code)
+	}
+	var body map[string]string
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body["stored_sha256"] == "" || body["received_sha256"] == "" || body["stored_sha256"] == body["received_sha256"] {
+		t.Fatalf("unexpected conflict body: %#v", body)
+	}
+}
+
+func TestUploadRejectsNonImage(t *testing.T) {
+	cfg := config.Config{MaxUploadMB: 1, DataDir: t.TempDir(), SessionPolicy: config.SessionPolicyRejectSecond, UploadRatePerSec: 100, UploadRateBurst: 100, WSRatePerSec: 100, WSRateBurst: 100, PSK: "secret", PSKHeader: "X-Ermete-PSK", WSAllowNoOrigin: true, RateLimitMaxEntries: 10000, RateLimitTTL: 30 * time.Minute, IdempotencyTTL: 10 * time.Minute, IdempotencyMax: 100}
+	h := testAPI(t, cfg)
+	for _, tc := range []struct {
+		contentType string
+		body        []byte
+	}{
+		{"image/png", []byte("<html>not an image</html>")},
+		{"image/jpeg", testPNG(t, "png-declared-as-jpeg")},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/v1/frames", bytes.NewReader(tc.body))
+		req.Header.Set("Content-Type", tc.contentType)
+		req.Header.Set("X-Ermete-PSK", "secret")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != http.StatusUnsupportedMediaType {
+			t.Fatalf("expected 415 for %s payload, got %d", tc.contentType, w.Code)
+		}
+	}
+}
+
+func TestUploadMultipartStreaming(t *testing.T) {
+	cfg := config.Config{MaxUploadMB: 1, DataDir: t.TempDir(), SessionPolicy: config.SessionPolicyRejectSecond, UploadRatePerSec: 100, UploadRateBurst: 100, WSRatePerSec: 100, WSRateBurst: 100, PSK: "secret", PSKHeader: "X-Ermete-PSK", WSAllowNoOrigin: true, RateLimitMaxEntries: 10000, RateLimitTTL: 30 * time.Minute, IdempotencyTTL: 10 * time.Minute, IdempotencyMax: 100}
+	h := testAPI(t, cfg)
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	_ = mw.WriteField("note", "ignored")
+	part, err := mw.CreatePart(textproto.MIMEHeader{"Content-Disposition": {`form-data; name="file"; filename="a.png"`}, "Content-Type": {"image/png"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := testPNG(t, "png-bytes")
+	_, _ = part.Write(payload)
+	_ = mw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/frames", &buf)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("X-Ermete-PSK", "secret")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Frame storage.FrameMeta `json:"frame"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Frame.ContentType != "image/png" || resp.Frame.Size != int64(len(payload)) || resp.Frame.Width != 10 {
+		t.Fatalf("unexpected frame: %+v", resp.Frame)
+	}
+}
+
+type batchItem struct {
+	frameID, idemKey, contentType string
+	payload                       []byte
+}
+
+func batchRequest(t *testing.T, items []batchItem) *http.Request {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for i, it := range items {
+		_ = mw.WriteField("frame_id", it.frameID)
+		if it.idemKey != "" {
+			_ = mw.WriteField("idempotency_key", it.idemKey)
+		}
+		part, err := mw.CreatePart(textproto.MIMEHeader{"Content-Disposition": {`form-data; name="file"; filename="` + it.frameID + `"`}, "Content-Type": {it.contentType}})
+		if err != nil {
+			t.Fatalf("item %d: %v", i, err)
+		}
+		_, _ = part.Write(it.payload)
+	}
+	_ = mw.Close()
+	req := httptest.NewRequest(http.MethodPost, "/v1/frames/batch", &buf)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("X-Ermete-PSK", "secret")
+	return req
+}
+
+type batchResponse struct {
+	Status  string `json:"status"`
+	Saved   int    `json:"saved"`
+	Failed  int    `json:"failed"`
+	Results []struct {
+		Index     int               `json:"index"`
+		Status    int               `json:"status"`
+		Duplicate bool              `json:"duplicate"`
+		Frame     storage.FrameMeta `json:"frame"`
+		Error     string            `json:"error"`
+		FrameID   string            `json:"frame_id"`
+	} `json:"results"`
+}
+
+func TestBatchUploadPartialSuccess(t *testing.T) {
+	h := testAPI(t, testFramesConfig(t))
+	first := testPNG(t, "batch-1")
+	req := batchRequest(t, []batchItem{
+		{frameID: "cam-1", idemKey: "k1", contentType: "image/png", payload: first},
+		{frameID: "cam-2", contentType: "image/png", payload: []byte("not an image")},
+		{frameID: "cam-1", idemKey: "k1", contentType: "image/png", payload: first},
+		{frameID: "cam-3", idemKey: "k1", contentType: "image/png", payload: testPNG(t, "other")},
+	})
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusMultiStatus {
+		t.Fatalf("expected 207, got %d body=%s", w.Code, w.Body.String())
+	}
+	var resp batchResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != "partial" || resp.Saved != 2 || resp.Failed != 2 || len(resp.Results) != 4 {
+		t.Fatalf("unexpected batch response: %+v", resp)
+	}
+	r := resp.Results
+	if r[0].Status != http.StatusOK || r[0].Frame.FrameID != "cam-1" || r[0].Duplicate {
+		t.Fatalf("unexpected first item: %+v", r[0])
+	}
+	if r[1].Status != http.StatusUnsupportedMediaType || r[1].FrameID != "cam-2" || r[1].Error == "" {
+		t.Fatalf("unexpected second item: %+v", r[1])
+	}
+	if r[2].Status != http.StatusOK || !r[2].Duplicate || r[2].Frame.FileName != r[0].Frame.FileName {
+		t.Fatalf("expected duplicate third item: %+v", r[2])
+	}
+	if r[3].Status != http.StatusConflict || r[3].Index != 3 {
+		t.Fatalf("expected conflict for fourth item: %+v", r[3])
+	}
+}
+
+func TestBatchUploadLimit(t *testing.T) {
+	cfg := testFramesConfig(t)
+	cfg.BatchMaxFrames = 1
+	h := testAPI(t, cfg)
+	req := batchRequest(t, []batchItem{
+		{frameID: "a", contentType: "image/png", payload: testPNG(t, "a")},
+		{frameID: "b", contentType: "image/png", payload: testPNG(t, "b")},
+	})
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	var resp batchResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusMultiStatus || resp.Saved != 1 || len(resp.Results) != 2 || resp.Results[1].Status != http.StatusRequestEntityTooLarge {
+		t.Fatalf("unexpected response %d: %+v", w.Code, resp)
+	}
+
+	empty := httptest.NewRequest(http.MethodPost, "/v1/frames/batch", bytes.NewReader([]byte("x")))
+	empty.Header.Set("Content-Type", "image/png")
+	empty.Header.Set("X-Ermete-PSK", "secret")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, empty)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for non-multipart batch, got %d", w.Code)
+	}
+}
+
+func TestBatchUploadFieldErrorFailsNextFile(t *testing.T) {
+	cfg := testFramesConfig(t)
+	cfg.BatchMaxFrames = 3
+	h := testAPI(t, cfg)
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	writeFile := func(name string) {
+		part, err := mw.CreatePart(textproto.MIMEHeader{"Content-Disposition": {`form-data; name="file"; filename="` + name + `"`}, "Content-Type": {"image/png"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = part.Write(testPNG(t, name))
+	}
+	writeFile("a")
+	_ = mw.WriteField("frame_id", string(bytes.Repeat([]byte("x"), 2048)))
+	writeFile("b")
+	writeFile("c")
+	_ = mw.Close()
+	req := httptest.NewRequest(http.MethodPost, "/v1/frames/batch", &buf)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("X-Ermete-PSK", "secret")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	var resp batchResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Saved != 2 || resp.Failed != 1 || len(resp.Results) != 3 {
+		t.Fatalf("unexpected batch response: %+v", resp)
+	}
+	for i, item := range resp.Results {
+		if item.Index != i {
+			t.Fatalf("result %d has index %d", i, item.Index)
+		}
+	}
+	if r := resp.Results[1]; r.Status != http.StatusBadRequest || r.Error == "" {
+		t.Fatalf("expected the field error on the second file: %+v", r)
+	}
+}
+
+func TestResumableUploadFlow(t *testing.T) {
+	h := testAPI(t, testFramesConfig(t))
+	payload := testPNG(t, "resumable-upload")
+	do := func(method, target string, body []byte, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, bytes.NewReader(body))
+		req.Header.Set("X-Ermete-PSK", "secret")
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do(http.MethodPost, "/v1/uploads", nil, map[string]string{"Upload-Length": strconv.Itoa(len(payload)), "X-Frame-Id": "cam-1", "X-Upload-Content-Type": "image/png"})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d body=%s", w.Code, w.Body.String())
+	}
+	loc := w.Header().Get("Location")
+	if loc == "" || w.Header().Get("Upload-Offset") != "0" {
+		t.Fatalf("unexpected create headers: %v", w.Header())
+	}
+
+	half := len(payload) / 2
+	if w := do(http.MethodPatch, loc, payload[:half], map[string]string{"Upload-Offset": "0"}); w.Code != http.StatusNoContent || w.Header().Get("Upload-Offset") != strconv.Itoa(half) {
+		t.Fatalf("unexpected patch response %d headers=%v", w.Code, w.Header())
+	}
+	if w := do(http.MethodPost, loc+"/finalize", nil, nil); w.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for incomplete upload, got %d", w.Code)
+	}
+	if w := do(http.MethodPatch, loc, payload, map[string]string{"Upload-Offset": "0"}); w.Code != http.StatusConflict || w.Header().Get("Upload-Offset") != strconv.Itoa(half) {
+		t.Fatalf("expected 409 with current offset, got %d headers=%v", w.Code, w.Header())
+	}
+	if w := do(http.MethodHead, loc, nil, nil); w.Code != http.StatusOK || w.Header().Get("Upload-Offset") != strconv.Itoa(half) || w.Header().Get("Upload-Length") != strconv.Itoa(len(payload)) {
+		t.Fatalf("unexpected head response %d headers=%v", w.Code, w.Header())
+	}
+	if w := do(http.MethodPatch, loc, payload[half:], map[string]string{"Upload-Offset": strconv.Itoa(half)}); w.Code != http.StatusNoContent {
+		t.Fatalf("unexpected patch response %d body=%s", w.Code, w.Body.String())
+	}
+
+	w = do(http.MethodPost, loc+"/finalize", nil, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Duplicate bool              `json:"duplicate"`
+		Frame     storage.FrameMeta `json:"frame"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Duplicate || resp.Frame.FrameID != "cam-1" || resp.Frame.Size != int64(len(payload)) {
+		t.Fatalf("unexpected finalize response: %+v", resp)
+	}
+	if w := do(http.MethodDelete, loc, nil, nil); w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204 on delete, got %d", w.Code)
+	}
+	if w := do(http.MethodGet, loc, nil, nil); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 after delete, got %d", w.Code)
+	}
+}
+
+func TestResumableUploadChunksAreRateLimited(t *testing.T) {
+	cfg := testFramesConfig(t)
+	cfg.UploadRatePerSec, cfg.UploadRateBurst = 0.001, 2
+	h := testAPI(t, cfg)
+	do := func(method, target string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, bytes.NewReader([]byte("x")))
+		req.Header.Set("X-Ermete-PSK", "secret")
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+	w := do(http.MethodPost, "/v1/uploads", map[string]string{"Upload-Length": "3"})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", w.Code)
+	}
+	loc := w.Header().Get("Location")
+	if w := do(http.MethodPatch, loc, map[string]string{"Upload-Offset": "0"}); w.Code != http.StatusNoContent {
+		t.Fatalf("expected first chunk to be accepted, got %d", w.Code)
+	}
+	if w := do(http.MethodPatch, loc, map[string]string{"Upload-Offset": "1"}); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected chunk beyond the upload burst to be rate limited, got %d", w.Code)
+	}
+	if w := do(http.MethodHead, loc, nil); w.Code != http.StatusOK {
+		t.Fatalf("expected status queries to stay outside the upload limit, got %d", w.Code)
+	}
+}
+
+func TestUploadRejectedOnFullDisk(t *testing.T) {
+	h, store := testAPIStore(t, testFramesConfig(t))
+	store.StartHealth(storage.HealthPolicy{MinFreeBytes: 1 << 62, Interval: 90 * time.Second})
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected /readyz 503 on a full disk, got %d", w.Code)
+	}
+
+	for _, path := range []string{"/v1/frames", "/v1/uploads"} {
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(testPNG(t, "full")))
+		req.Header.Set("Content-Type", "image/png")
+		req.Header.Set("X-Ermete-PSK", "secret")
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != http.StatusInsufficientStorage || w.Header().Get("Retry-After") != "90" {
+			t.Fatalf("%s: expected 507 with Retry-After 90, got %d %q body=%s", path, w.Code, w.Header().Get("Retry-After"), w.Body.String())
+		}
+	}
+}
+
+func TestUploadChecksumVerification(t *testing.T) {
+	h, store := testAPIStore(t, testFramesConfig(t))
+	payload := testPNG(t, "checked")
+	sha := sha256.Sum256(payload)
+	md := md5.Sum(payload)
+	shaHex, md5B64 := hex.EncodeToString(sha[:]), base64.StdEncoding.EncodeToString(md[:])
+
+	upload := func(sha, md5 string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/frames", bytes.NewReader(payload))
+		req.Header.Set("Content-Type", "image/png")
+		req.Header.Set("X-Ermete-PSK", "secret")
+		req.Header.Set("X-Content-SHA256", sha)
+		req.Header.Set("Content-MD5", md5)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	w := upload(strings.ToUpper(shaHex), md5B64)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", w.Code, w.Body.String())
+	}
+	var ok struct {
+		Verified map[string]string `json:"verified"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&ok); err != nil {
+		t.Fatal(err)
+	}
+	if ok.Verified["sha256"] != shaHex || ok.Verified["md5"] != md5B64 {
+		t.Fatalf("unexpected verified digests: %#v", ok.Verified)
+	}
+
+	wrong := sha256.Sum256([]byte("other"))
+	for _, tc := range []struct{ sha, md5, algorithm string }{
+		{hex.EncodeToString(wrong[:]), "", "sha256"},
+		{"", base64.StdEncoding.EncodeToString(wrong[:16]), "md5"},
+	} {
+		w := upload(tc.sha, tc.md5)
+		var body map[string]string
+		_ = json.NewDecoder(w.Body).Decode(&body)
+		if w.Code != http.StatusUnprocessableEntity || body["algorithm"] != tc.algorithm || body["actual"] == "" {
+			t.Fatalf("expected 422 %s mismatch, got %d %#v", tc.algorithm, w.Code, body)
+		}
+	}
+	if page, err := store.ListFrames(storage.FrameQuery{}); err != nil || len(page.Frames) != 1 {
+		t.Fatalf("mismatched uploads must not be stored: %d frames err=%v", len(page.Frames), err)
+	}
+
+	for _, bad := range [][2]string{{"abc", ""}, {"", "not base64"}} {
+		if w := upload(bad[0], bad[1]); w.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400 for malformed digest %q, got %d", bad, w.Code)
+		}
+	}
+}
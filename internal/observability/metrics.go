@@ -3,17 +3,48 @@ package observability
 import "github.com/prometheus/client_golang/prometheus"
 
 type Metrics struct {
-	FramesUploadedTotal       prometheus.Counter
-	FrameUploadBytesTotal     prometheus.Counter
-	FrameUploadErrors         prometheus.Counter
-	WSConnectionsTotal        prometheus.Counter
-	WSRejectTotal             prometheus.Counter
-	WebRTCPacketsIn           prometheus.Counter
-	WebRTCPacketsOut          prometheus.Counter
-	RateLimiterEntries        prometheus.Gauge
-	RateLimiterEvictionsTotal prometheus.Counter
-	IdempotencyEntries        prometheus.Gauge
-	IdempotencyEvictionsTotal prometheus.Counter
+	FramesUploadedTotal        prometheus.Counter
+	FrameUploadBytesTotal      prometheus.Counter
+	FrameUploadErrors          prometheus.Counter
+	WSConnectionsTotal         prometheus.Counter
+	WSRejectTotal              prometheus.Counter
+	WebRTCPacketsIn            prometheus.Counter
+	WebRTCPacketsOut           prometheus.Counter
+	RateLimiterEntries         prometheus.Gauge
+	RateLimiterEvictionsTotal  prometheus.Counter
+	IdempotencyEntries         prometheus.Gauge
+	IdempotencyEvictionsTotal  prometheus.Counter
+	IdempotencyConflictsTotal  prometheus.Counter
+	FramesStored               prometheus.Gauge
+	FramesStoredBytes          prometheus.Gauge
+	RetentionDeletedFiles      prometheus.Counter
+	RetentionDeletedBytes      prometheus.Counter
+	UploadsActive              prometheus.Gauge
+	UploadsExpiredTotal        prometheus.Counter
+	FramesSkippedTotal         prometheus.Counter
+	FramesUnchangedTotal       prometheus.Counter
+	MotionScore                prometheus.Histogram
+	DCEventsDroppedTotal       prometheus.Counter
+	WebhookDeliveredTotal      prometheus.Counter
+	WebhookFailedAttemptsTotal prometheus.Counter
+	WebhookDeadLetteredTotal   prometheus.Counter
+	WebhookQueueDepth          prometheus.Gauge
+	WebhookDeadLetterDepth     prometheus.Gauge
+	WebhookDroppedTotal        prometheus.Counter
+	ScrubCheckedFrames         prometheus.Gauge
+	ScrubMissingFrames         prometheus.Gauge
+	ScrubCorruptedFrames       prometheus.Gauge
+	ScrubOrphanedFiles         prometheus.Gauge
+	ScrubUnverifiedFrames      prometheus.Gauge
+	ScrubQuarantinedTotal      prometheus.Counter
+	ScrubLastRunTimestamp      prometheus.Gauge
+	StorageFreeBytes           prometheus.Gauge
+	StorageTotalBytes          prometheus.Gauge
+	StorageWritable            prometheus.Gauge
+	StorageRejectedTotal       prometheus.Counter
+	ClockSkew                  prometheus.Histogram
+	ClientClockSkew            *prometheus.GaugeVec
+	InvalidTimestampsTotal     prometheus.Counter
 }
 
 func NewMetrics(reg prometheus.Registerer) *Metrics {
@@ -30,6 +61,39 @@ func NewMetrics(reg prometheus.Registerer) *Metrics {
 		RateLimiterEvictionsTotal: promautoCounter(reg, "ermete_rate_limiter_evictions_total", "Evicted in-app rate limiter entries"),
 		IdempotencyEntries:        promautoGauge(reg, "ermete_idempotency_entries", "Current idempotency key entries in memory"),
 		IdempotencyEvictionsTotal: promautoCounter(reg, "ermete_idempotency_evictions_total", "Evicted idempotency keys from in-memory store"),
+		IdempotencyConflictsTotal: promautoCounter(reg, "ermete_idempotency_conflicts_total", "Idempotency keys reused with a different payload"),
+		FramesStored:              promautoGauge(reg, "ermete_frames_stored", "Current number of frames in the metadata index"),
+		FramesStoredBytes:         promautoGauge(reg, "ermete_frames_stored_bytes", "Current total size of indexed frames"),
+		RetentionDeletedFiles:     promautoCounter(reg, "ermete_retention_deleted_files_total", "Frames deleted by the retention sweeper"),
+		RetentionDeletedBytes:     promautoCounter(reg, "ermete_retention_deleted_bytes_total", "Bytes deleted by the retention sweeper"),
+		UploadsActive:             promautoGauge(reg, "ermete_resumable_uploads_active", "Resumable uploads not yet finalized"),
+		UploadsExpiredTotal:       promautoCounter(reg, "ermete_resumable_uploads_expired_total", "Abandoned resumable uploads removed after expiry"),
+		FramesSkippedTotal:        promautoCounter(reg, "ermete_frames_phash_skipped_total", "Frames not stored because they matched the previous frame's perceptual hash"),
+		FramesUnchangedTotal:      promautoCounter(reg, "ermete_frames_phash_unchanged_total", "Stored frames flagged as unchanged by the perceptual hash"),
+		MotionScore: promautoHistogram(reg, "ermete_frame_motion_score", "Pixel-difference motion score between consecutive frames",
+			[]float64{0.005, 0.01, 0.02, 0.05, 0.1, 0.2, 0.35, 0.5, 0.75, 1}),
+		DCEventsDroppedTotal:       promautoCounter(reg, "ermete_dc_events_dropped_total", "Frame events not delivered because the peer's cmd queue was full"),
+		WebhookDeliveredTotal:      promautoCounter(reg, "ermete_webhook_delivered_total", "Webhook deliveries acknowledged with a 2xx response"),
+		WebhookFailedAttemptsTotal: promautoCounter(reg, "ermete_webhook_failed_attempts_total", "Webhook delivery attempts that failed and were retried or dead-lettered"),
+		WebhookDeadLetteredTotal:   promautoCounter(reg, "ermete_webhook_dead_lettered_total", "Webhook deliveries moved to the dead-letter directory"),
+		WebhookQueueDepth:          promautoGauge(reg, "ermete_webhook_queue_depth", "Webhook deliveries waiting in the persistent queue"),
+		WebhookDeadLetterDepth:     promautoGauge(reg, "ermete_webhook_dead_letter_depth", "Webhook deliveries in the dead-letter directory"),
+		WebhookDroppedTotal:        promautoCounter(reg, "ermete_webhook_dropped_total", "Webhook deliveries discarded because a target queue or the dead-letter directory was full"),
+		ScrubCheckedFrames:         promautoGauge(reg, "ermete_scrub_checked_frames", "Frames verified by the last integrity scrub"),
+		ScrubMissingFrames:         promautoGauge(reg, "ermete_scrub_missing_frames", "Indexed frames whose file was missing in the last integrity scrub"),
+		ScrubCorruptedFrames:       promautoGauge(reg, "ermete_scrub_corrupted_frames", "Frames whose content did not match the recorded SHA-256 in the last integrity scrub"),
+		ScrubOrphanedFiles:         promautoGauge(reg, "ermete_scrub_orphaned_files", "Files under the frames directory not referenced by the index in the last integrity scrub"),
+		ScrubUnverifiedFrames:      promautoGauge(reg, "ermete_scrub_unverified_frames", "Encrypted frames the last integrity scrub had no key for"),
+		ScrubQuarantinedTotal:      promautoCounter(reg, "ermete_scrub_quarantined_total", "Files moved to quarantine by the integrity scrub"),
+		ScrubLastRunTimestamp:      promautoGauge(reg, "ermete_scrub_last_run_timestamp_seconds", "Unix time the last integrity scrub finished"),
+		StorageFreeBytes:           promautoGauge(reg, "ermete_storage_free_bytes", "Free bytes on the filesystem holding DATA_DIR at the last health probe"),
+		StorageTotalBytes:          promautoGauge(reg, "ermete_storage_total_bytes", "Size in bytes of the filesystem holding DATA_DIR at the last health probe"),
+		StorageWritable:            promautoGauge(reg, "ermete_storage_writable", "1 if the last health probe could write to the frames directory"),
+		StorageRejectedTotal:       promautoCounter(reg, "ermete_storage_rejected_frames_total", "Frames rejected because storage was full or not writable"),
+		ClockSkew: promautoHistogram(reg, "ermete_frame_clock_skew_seconds", "Received time minus client capture time of stored frames",
+			[]float64{-3600, -300, -60, -10, -1, 0, 1, 10, 60, 300, 3600}),
+		ClientClockSkew:        promautoGaugeVec(reg, "ermete_client_clock_skew_seconds", "Clock skew of the last frame stored per client device", "device_id"),
+		InvalidTimestampsTotal: promautoCounter(reg, "ermete_frames_invalid_timestamp_total", "Stored frames whose X-Timestamp could not be parsed"),
 	}
 	return m
 }
@@ -45,3 +109,15 @@ func promautoGauge(reg prometheus.Registerer, name, help string) prometheus.Gaug
 	reg.MustRegister(gauge)
 	return gauge
 }
+
+func promautoGaugeVec(reg prometheus.Registerer, name, help string, labels ...string) *prometheus.GaugeVec {
+	gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: name, Help: help}, labels)
+	reg.MustRegister(gauge)
+	return gauge
+}
+
+func promautoHistogram(reg prometheus.Registerer, name, help string, buckets []float64) prometheus.Histogram {
+	hist := prometheus.NewHistogram(prometheus.HistogramOpts{Name: name, Help: help, Buckets: buckets})
+	reg.MustRegister(hist)
+	return hist
+}
@@ -23,6 +23,20 @@ type SessionRef interface {
 	Close(reason string)
 }
 
+const (
+	EventAcquired = "session_acquired"
+	EventReleased = "session_released"
+	EventKicked   = "session_kicked"
+)
+
+// Event reports a change of the active session to observers.
+type Event struct {
+	Type      string    `json:"type"`
+	SessionID string    `json:"session_id"`
+	Reason    string    `json:"reason,omitempty"`
+	At        time.Time `json:"at"`
+}
+
 type Snapshot struct {
 	State      State     `json:"state"`
 	SessionID  string    `json:"session_id,omitempty"`
@@ -35,25 +49,40 @@ type Manager struct {
 	state      State
 	active     SessionRef
 	lastActive time.Time
+	observers  []func(Event)
 }
 
 func NewManager(policy config.SessionPolicy) *Manager {
 	return &Manager{policy: policy, state: StateDisconnected}
 }
 
+// Observe registers fn for session events. It must be called before the
+// manager is used; fn runs on the caller's goroutine after the manager's
+// lock is released.
+func (m *Manager) Observe(fn func(Event)) {
+	m.observers = append(m.observers, fn)
+}
+
 func (m *Manager) Acquire(s SessionRef) error {
 	m.mu.Lock()
-	defer m.mu.Unlock()
-
-	if m.active != nil {
-		if m.policy == config.SessionPolicyRejectSecond {
-			return ErrSessionAlreadyActive
-		}
-		m.active.Close("replaced_by_new_session")
+	kicked := m.active
+	if kicked != nil && m.policy == config.SessionPolicyRejectSecond {
+		m.mu.Unlock()
+		return ErrSessionAlreadyActive
 	}
 	m.active = s
 	m.state = StateConnecting
 	m.lastActive = time.Now().UTC()
+	now := m.lastActive
+	m.mu.Unlock()
+
+	// the kicked session releases itself from Close, which must not run
+	// under m.mu
+	if kicked != nil {
+		kicked.Close("replaced_by_new_session")
+		m.notify(Event{Type: EventKicked, SessionID: kicked.ID(), Reason: "replaced_by_new_session", At: now})
+	}
+	m.notify(Event{Type: EventAcquired, SessionID: s.ID(), At: now})
 	return nil
 }
 
@@ -72,12 +101,23 @@ func (m *Manager) Touch() {
 
 func (m *Manager) Release(sessionID string) {
 	m.mu.Lock()
-	defer m.mu.Unlock()
-	if m.active != nil && m.active.ID() == sessionID {
+	released := m.active != nil && m.active.ID() == sessionID
+	if released {
 		m.active = nil
 		m.state = StateDisconnected
 		m.lastActive = time.Now().UTC()
 	}
+	now := m.lastActive
+	m.mu.Unlock()
+	if released {
+		m.notify(Event{Type: EventReleased, SessionID: sessionID, At: now})
+	}
+}
+
+func (m *Manager) notify(ev Event) {
+	for _, fn := range m.observers {
+		fn(ev)
+	}
 }
 
 func (m *Manager) Snapshot() Snapshot {
@@ -40,3 +40,27 @@ func TestKickPolicy(t *testing.T) {
 		t.Fatal("expected previous session to close")
 	}
 }
+
+func TestObserveSessionEvents(t *testing.T) {
+	m := NewManager(config.SessionPolicyKickPrevious)
+	var got []string
+	m.Observe(func(ev Event) { got = append(got, ev.Type+":"+ev.SessionID) })
+	a := &fakeSession{id: "a"}
+	if err := m.Acquire(a); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Acquire(&fakeSession{id: "b"}); err != nil {
+		t.Fatal(err)
+	}
+	m.Release("a")
+	m.Release("b")
+	want := []string{"session_acquired:a", "session_kicked:a", "session_acquired:b", "session_released:b"}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+}
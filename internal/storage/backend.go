@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Backend stores committed frame blobs under slash-separated keys. Payloads
+// are always spooled and processed locally first; a backend only receives
+// complete files.
+type Backend interface {
+	// Commit stores the local file at srcPath under key. The backend takes
+	// ownership of srcPath, which may be moved or removed.
+	Commit(key, srcPath, contentType string) error
+	// Open returns the blob stored under key, or ErrFrameNotFound.
+	Open(key string) (io.ReadSeekCloser, error)
+	// Remove deletes key; removing a missing key is not an error.
+	Remove(key string) error
+	// Rename moves a blob to a new key; a missing source is not an error.
+	Rename(oldKey, newKey string) error
+	// Location describes where key is stored, for FrameMeta.Path.
+	Location(key string) string
+}
+
+func validKey(key string) bool {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") || path.Clean(key) != key {
+		return false
+	}
+	return key != ".." && !strings.HasPrefix(key, "../")
+}
+
+type LocalBackend struct {
+	root string
+}
+
+func NewLocalBackend(root string) *LocalBackend {
+	return &LocalBackend{root: root}
+}
+
+func (b *LocalBackend) path(key string) (string, error) {
+	if !validKey(key) {
+		return "", ErrFrameNotFound
+	}
+	p := filepath.Join(b.root, filepath.FromSlash(key))
+	rel, err := filepath.Rel(b.root, p)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", ErrFrameNotFound
+	}
+	return p, nil
+}
+
+func (b *LocalBackend) Commit(key, srcPath, _ string) error {
+	dst, err := b.path(key)
+	if err != nil {
+		return fmt.Errorf("invalid key %q", key)
+	}
+	dir := filepath.Dir(dst)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("create partition dir: %w", err)
+	}
+	if err := os.Rename(srcPath, dst); err != nil {
+		return err
+	}
+	if err := syncDir(dir); err != nil {
+		_ = os.Remove(dst)
+		return err
+	}
+	return nil
+}
+
+func (b *LocalBackend) Open(key string) (io.ReadSeekCloser, error) {
+	p, err := b.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrFrameNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open frame: %w", err)
+	}
+	return f, nil
+}
+
+func (b *LocalBackend) Remove(key string) error {
+	p, err := b.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("remove frame: %w", err)
+	}
+	b.pruneEmptyDirs(key)
+	return nil
+}
+
+func (b *LocalBackend) Rename(oldKey, newKey string) error {
+	src, err := b.path(oldKey)
+	if err != nil {
+		return err
+	}
+	dst, err := b.path(newKey)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	// a missing source means an interrupted migration already moved it, or
+	// that it is lost, which a rename cannot fix either way
+	if err := os.Rename(src, dst); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := syncDir(filepath.Dir(dst)); err != nil {
+		return err
+	}
+	b.pruneEmptyDirs(oldKey)
+	return nil
+}
+
+func (b *LocalBackend) Location(key string) string {
+	return filepath.Join(b.root, filepath.FromSlash(key))
+}
+
+// pruneEmptyDirs removes the partition directories left empty by key,
+// stopping at the backend root.
+func (b *LocalBackend) pruneEmptyDirs(key string) {
+	for dir := path.Dir(key); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if err := os.Remove(filepath.Join(b.root, filepath.FromSlash(dir))); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalBackendKeysStayInsideRoot(t *testing.T) {
+	b := NewLocalBackend(t.TempDir())
+	for _, key := range []string{"../frames.index", "..", "a/../../x", "", "/etc/passwd", "a//b"} {
+		if _, err := b.Open(key); err != ErrFrameNotFound {
+			t.Fatalf("expected %q to be refused, got %v", key, err)
+		}
+	}
+	if _, err := b.path("2026/01/02/ok.png"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestLocalBackendLifecycle(t *testing.T) {
+	root := t.TempDir()
+	b := NewLocalBackend(root)
+	src := filepath.Join(root, ".spool-test")
+	if err := os.WriteFile(src, []byte("payload"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Commit("2026/01/02/a.png", src, "image/png"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Rename("2026/01/02/a.png", "b.png"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "2026")); !os.IsNotExist(err) {
+		t.Fatalf("expected empty partition dirs to be pruned, got %v", err)
+	}
+	f, err := b.Open("b.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(f)
+	f.Close()
+	if string(data) != "payload" {
+		t.Fatalf("unexpected content %q", data)
+	}
+	if err := b.Remove("b.png"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Remove("b.png"); err != nil {
+		t.Fatalf("removing a missing key should succeed: %v", err)
+	}
+	if _, err := b.Open("b.png"); err != ErrFrameNotFound {
+		t.Fatalf("expected not found, got %v", err)
+	}
+}
@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// ExpectedDigests are the checksums a client declared for a payload; nil
+// fields are not checked.
+type ExpectedDigests struct {
+	SHA256 []byte
+	MD5    []byte
+}
+
+func (d ExpectedDigests) Empty() bool {
+	return d.SHA256 == nil && d.MD5 == nil
+}
+
+// ChecksumMismatchError reports a payload whose digest differs from the one
+// the client declared. SHA-256 digests are hex encoded and MD5 digests base64
+// encoded, as in the X-Content-SHA256 and Content-MD5 headers.
+type ChecksumMismatchError struct {
+	Algorithm string
+	Expected  string
+	Actual    string
+}
+
+func (e *ChecksumMismatchError) Error() string {
+	return fmt.Sprintf("%s checksum mismatch: declared %s, received %s", e.Algorithm, e.Expected, e.Actual)
+}
+
+// VerifyReader hashes everything read from r and, once r is exhausted,
+// returns a *ChecksumMismatchError instead of io.EOF if a digest differs
+// from want. SaveFrame fails with that error before anything is committed.
+func VerifyReader(r io.Reader, want ExpectedDigests) io.Reader {
+	v := &verifyingReader{r: r, want: want}
+	var sinks []io.Writer
+	if want.SHA256 != nil {
+		v.sha256 = sha256.New()
+		sinks = append(sinks, v.sha256)
+	}
+	if want.MD5 != nil {
+		v.md5 = md5.New()
+		sinks = append(sinks, v.md5)
+	}
+	v.w = io.MultiWriter(sinks...)
+	return v
+}
+
+type verifyingReader struct {
+	r      io.Reader
+	want   ExpectedDigests
+	sha256 hash.Hash
+	md5    hash.Hash
+	w      io.Writer
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	_, _ = v.w.Write(p[:n])
+	if err == io.EOF {
+		if merr := v.check(); merr != nil {
+			return n, merr
+		}
+	}
+	return n, err
+}
+
+func (v *verifyingReader) check() error {
+	if v.sha256 != nil {
+		if got := v.sha256.Sum(nil); !bytes.Equal(got, v.want.SHA256) {
+			return &ChecksumMismatchError{Algorithm: "sha256", Expected: hex.EncodeToString(v.want.SHA256), Actual: hex.EncodeToString(got)}
+		}
+	}
+	if v.md5 != nil {
+		if got := v.md5.Sum(nil); !bytes.Equal(got, v.want.MD5) {
+			return &ChecksumMismatchError{Algorithm: "md5", Expected: base64.StdEncoding.EncodeToString(v.want.MD5), Actual: base64.StdEncoding.EncodeToString(got)}
+		}
+	}
+	return nil
+}
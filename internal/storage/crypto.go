@@ -0,0 +1,343 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+var ErrKeyUnavailable = errors.New("encryption key unavailable")
+
+// sealMagic starts every encrypted blob. No supported image format begins
+// with a NUL byte, so plaintext frames written before encryption was
+// enabled are told apart by their first bytes.
+const sealMagic = "\x00ERMENC1"
+
+const (
+	keySize   = 32
+	nonceSize = 12
+	tagSize   = 16
+)
+
+var validKeyID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// Keyring holds the AES-256 key-encryption keys. Every blob is encrypted
+// with a fresh data key, which is itself encrypted with the active key and
+// stored in the blob header together with the key ID:
+//
+//	magic | len(id) | id | nonce | sealed data key | nonce | ciphertext
+//
+// Older keys stay in the ring so frames written under them remain readable
+// until they are rotated.
+type Keyring struct {
+	active string
+	keys   map[string][]byte
+}
+
+// ParseKeyring reads one "<id>:<base64 32-byte key>" entry per line or
+// comma-separated item; blank lines and lines starting with # are ignored.
+// activeID selects the key for new frames, by default the last entry.
+func ParseKeyring(data, activeID string) (*Keyring, error) {
+	k := &Keyring{keys: map[string][]byte{}}
+	last := ""
+	for i, line := range strings.FieldsFunc(data, func(r rune) bool { return r == '\n' || r == ',' }) {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		id, encoded, ok := strings.Cut(line, ":")
+		id = strings.TrimSpace(id)
+		if !ok || !validKeyID.MatchString(id) {
+			return nil, fmt.Errorf("invalid key entry %d: expected <id>:<base64 key>", i+1)
+		}
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil || len(key) != keySize {
+			return nil, fmt.Errorf("key %s must be %d bytes of standard base64", id, keySize)
+		}
+		if _, dup := k.keys[id]; dup {
+			return nil, fmt.Errorf("duplicate key id %s", id)
+		}
+		k.keys[id] = key
+		last = id
+	}
+	if last == "" {
+		return nil, errors.New("no encryption keys")
+	}
+	k.active = last
+	if activeID != "" {
+		if _, ok := k.keys[activeID]; !ok {
+			return nil, fmt.Errorf("active key %s is not in the keyring", activeID)
+		}
+		k.active = activeID
+	}
+	return k, nil
+}
+
+// LoadKeyring parses the key file at path.
+func LoadKeyring(path, activeID string) (*Keyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read key file: %w", err)
+	}
+	return ParseKeyring(string(data), activeID)
+}
+
+func (k *Keyring) ActiveID() string {
+	return k.active
+}
+
+func (k *Keyring) seal(plain []byte) ([]byte, error) {
+	dataKey := make([]byte, keySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	header := []byte(sealMagic)
+	header = append(header, byte(len(k.active)))
+	header = append(header, k.active...)
+	wrapped, err := gcmSeal(k.keys[k.active], dataKey, header)
+	if err != nil {
+		return nil, err
+	}
+	header = append(header, wrapped...)
+	body, err := gcmSeal(dataKey, plain, header)
+	if err != nil {
+		return nil, err
+	}
+	return append(header, body...), nil
+}
+
+// open decrypts a sealed blob and returns the ID of the key it was sealed
+// with.
+func (k *Keyring) open(blob []byte) ([]byte, string, error) {
+	corrupt := fmt.Errorf("%w: malformed encrypted blob", ErrInvalidPayload)
+	if !isSealed(blob) || len(blob) < len(sealMagic)+1 {
+		return nil, "", corrupt
+	}
+	idEnd := len(sealMagic) + 1 + int(blob[len(sealMagic)])
+	wrapEnd := idEnd + nonceSize + keySize + tagSize
+	if len(blob) < wrapEnd+nonceSize+tagSize {
+		return nil, "", corrupt
+	}
+	id := string(blob[len(sealMagic)+1 : idEnd])
+	if k == nil {
+		return nil, id, ErrKeyUnavailable
+	}
+	kek, ok := k.keys[id]
+	if !ok {
+		return nil, id, fmt.Errorf("%w: %s", ErrKeyUnavailable, id)
+	}
+	dataKey, err := gcmOpen(kek, blob[idEnd:wrapEnd], blob[:idEnd])
+	if err != nil {
+		return nil, id, fmt.Errorf("unwrap data key: %w", err)
+	}
+	plain, err := gcmOpen(dataKey, blob[wrapEnd:], blob[:wrapEnd])
+	if err != nil {
+		return nil, id, fmt.Errorf("decrypt frame: %w", err)
+	}
+	return plain, id, nil
+}
+
+func isSealed(b []byte) bool {
+	return bytes.HasPrefix(b, []byte(sealMagic))
+}
+
+func gcmSeal(key, plain, aad []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, nonceSize, nonceSize+len(plain)+tagSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plain, aad), nil
+}
+
+func gcmOpen(key, sealed, aad []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], aad)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealFile replaces the plaintext at path with its encryption under the
+// active key.
+func (k *Keyring) sealFile(path string) error {
+	plain, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	blob, err := k.seal(plain)
+	if err != nil {
+		return fmt.Errorf("encrypt frame: %w", err)
+	}
+	return replaceFile(path, blob)
+}
+
+func replaceFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+	}
+	return err
+}
+
+// openBlob opens key on the backend and decrypts it if it is sealed, so
+// callers always read plaintext. Sealed blobs are decrypted in memory; they
+// are bounded by the upload size limit.
+func (s *FrameStore) openBlob(key string) (io.ReadSeekCloser, error) {
+	f, err := s.backend.Open(key)
+	if err != nil {
+		return nil, err
+	}
+	head := make([]byte, len(sealMagic))
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		_ = f.Close()
+		return nil, err
+	}
+	if !isSealed(head[:n]) {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+		return f, nil
+	}
+	rest, err := io.ReadAll(f)
+	_ = f.Close()
+	if err != nil {
+		return nil, err
+	}
+	plain, _, err := s.keys.open(append(head, rest...))
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", key, err)
+	}
+	return nopSeekCloser{bytes.NewReader(plain)}, nil
+}
+
+type nopSeekCloser struct {
+	*bytes.Reader
+}
+
+func (nopSeekCloser) Close() error { return nil }
+
+// RotationResult reports a RotateKeys run.
+type RotationResult struct {
+	Rotated int
+	Current int
+	Missing int
+}
+
+// RotateKeys re-encrypts every frame and thumbnail that is not sealed with
+// the active key, plaintext frames included, and records the key in the
+// index. Blobs are rewritten in place and the blob header, not the index,
+// names the key used to read them, so an interrupted run can simply be
+// repeated.
+func (s *FrameStore) RotateKeys() (RotationResult, error) {
+	var res RotationResult
+	if s.readOnly {
+		return res, ErrReadOnly
+	}
+	if s.keys == nil {
+		return res, ErrKeyUnavailable
+	}
+	active := s.keys.ActiveID()
+	s.mu.Lock()
+	frames := make([]FrameMeta, 0, len(s.frames))
+	for _, m := range s.frames {
+		frames = append(frames, *m)
+	}
+	s.mu.Unlock()
+
+	for _, m := range frames {
+		if m.KeyID == active {
+			res.Current++
+			continue
+		}
+		err := s.resealBlob(m.FileName, m.ContentType)
+		if err == nil && m.ThumbnailFileName != "" {
+			err = s.resealBlob(m.ThumbnailFileName, "image/jpeg")
+		}
+		if errors.Is(err, ErrFrameNotFound) {
+			res.Missing++
+			continue
+		}
+		if err != nil {
+			return res, fmt.Errorf("rotate %s: %w", m.FileName, err)
+		}
+		s.mu.Lock()
+		if cur, ok := s.byName[m.FileName]; ok {
+			updated := *cur
+			updated.KeyID = active
+			if err := s.indexJournal.append(indexRecord{Op: "put", Frame: &updated}); err != nil {
+				s.mu.Unlock()
+				return res, fmt.Errorf("index %s: %w", m.FileName, err)
+			}
+			s.putIndexLocked(updated)
+		}
+		s.mu.Unlock()
+		res.Rotated++
+	}
+	return res, nil
+}
+
+func (s *FrameStore) resealBlob(key, contentType string) error {
+	r, err := s.openBlob(key)
+	if err != nil {
+		return err
+	}
+	plain, err := io.ReadAll(r)
+	_ = r.Close()
+	if err != nil {
+		return err
+	}
+	blob, err := s.keys.seal(plain)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(s.framesDir, spoolPrefix+"*")
+	if err != nil {
+		return err
+	}
+	_ = tmp.Close()
+	if err := replaceFile(tmp.Name(), blob); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := s.backend.Commit(key, tmp.Name(), contentType); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"image/jpeg"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testKey(b byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, keySize))
+}
+
+func openEncryptedStore(t *testing.T, dir string, keys *Keyring) *FrameStore {
+	t.Helper()
+	store, err := OpenFrameStore(Options{DataDir: dir, Keyring: keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	return store
+}
+
+func readFrame(t *testing.T, store *FrameStore, fileName string) ([]byte, error) {
+	t.Helper()
+	_, f, err := store.OpenFrame(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+func TestKeyring(t *testing.T) {
+	keys, err := ParseKeyring("# rotated 2026-10\nold:"+testKey(1)+"\nnew:"+testKey(2)+"\n", "")
+	if err != nil || keys.ActiveID() != "new" {
+		t.Fatalf("unexpected keyring: %v err=%v", keys, err)
+	}
+	blob, err := keys.seal([]byte("frame"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain, id, err := keys.open(blob); err != nil || string(plain) != "frame" || id != "new" {
+		t.Fatalf("round trip failed: %q %s %v", plain, id, err)
+	}
+	blob[len(blob)-1] ^= 1
+	if _, _, err := keys.open(blob); err == nil {
+		t.Fatal("expected tampered blob to be rejected")
+	}
+
+	oldOnly, err := ParseKeyring("old:"+testKey(1), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, _ := keys.seal([]byte("frame"))
+	if _, _, err := oldOnly.open(sealed); !errors.Is(err, ErrKeyUnavailable) {
+		t.Fatalf("expected ErrKeyUnavailable, got %v", err)
+	}
+
+	for _, bad := range []string{"", "nokey", "k:" + base64.StdEncoding.EncodeToString([]byte("short")), "k:" + testKey(1) + ",k:" + testKey(2)} {
+		if _, err := ParseKeyring(bad, ""); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+	if _, err := ParseKeyring("a:"+testKey(1), "b"); err == nil {
+		t.Fatal("expected error for an unknown active key")
+	}
+}
+
+func TestEncryptedFramesAtRest(t *testing.T) {
+	dir := t.TempDir()
+	keys, err := ParseKeyring("k1:"+testKey(1), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := openEncryptedStore(t, dir, keys)
+	store.Use(NewThumbnailer(16, 80))
+	payload := testImage(t, "png", "secret-scene")
+	meta, err := store.SaveFrame(FrameInput{FrameID: "f"}, bytes.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.KeyID != "k1" || meta.Size != int64(len(payload)) {
+		t.Fatalf("unexpected meta: %+v", meta)
+	}
+
+	onDisk, err := os.ReadFile(filepath.Join(dir, "frames", filepath.FromSlash(meta.FileName)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isSealed(onDisk) || bytes.Contains(onDisk, payload[8:]) {
+		t.Fatal("frame is stored in plaintext")
+	}
+	got, err := readFrame(t, store, meta.FileName)
+	if err != nil || !bytes.Equal(got, payload) {
+		t.Fatalf("expected decrypted frame, err=%v", err)
+	}
+	_, thumb, err := store.OpenThumbnail(meta.FileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer thumb.Close()
+	if _, err := jpeg.Decode(thumb); err != nil {
+		t.Fatalf("thumbnail not decrypted: %v", err)
+	}
+	store.Close()
+
+	noKeys := openEncryptedStore(t, dir, nil)
+	if _, err := readFrame(t, noKeys, meta.FileName); !errors.Is(err, ErrKeyUnavailable) {
+		t.Fatalf("expected ErrKeyUnavailable without keys, got %v", err)
+	}
+}
+
+func TestRotateKeys(t *testing.T) {
+	dir := t.TempDir()
+	plain := newTestStore(t, dir)
+	legacy, err := plain.SaveFrame(FrameInput{FrameID: "plain"}, bytes.NewReader(testImage(t, "png", "a")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain.Close()
+
+	k1, _ := ParseKeyring("k1:"+testKey(1), "")
+	store := openEncryptedStore(t, dir, k1)
+	sealed, err := store.SaveFrame(FrameInput{FrameID: "sealed"}, bytes.NewReader(testImage(t, "png", "b")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	both, _ := ParseKeyring("k1:"+testKey(1)+"\nk2:"+testKey(2), "k2")
+	store = openEncryptedStore(t, dir, both)
+	res, err := store.RotateKeys()
+	if err != nil || res.Rotated != 2 || res.Current != 0 {
+		t.Fatalf("unexpected rotation: %+v err=%v", res, err)
+	}
+	if again, err := store.RotateKeys(); err != nil || again.Rotated != 0 || again.Current != 2 {
+		t.Fatalf("expected a second run to be a no-op: %+v err=%v", again, err)
+	}
+	store.Close()
+
+	k2, _ := ParseKeyring("k2:"+testKey(2), "")
+	store = openEncryptedStore(t, dir, k2)
+	for name, want := range map[string][]byte{legacy.FileName: testImage(t, "png", "a"), sealed.FileName: testImage(t, "png", "b")} {
+		if m, _ := store.Frame(name); m.KeyID != "k2" {
+			t.Fatalf("index not updated for %s: %+v", name, m)
+		}
+		got, err := readFrame(t, store, name)
+		if err != nil || !bytes.Equal(got, want) {
+			t.Fatalf("%s not readable with the new key alone: %v", name, err)
+		}
+	}
+	matches, _ := filepath.Glob(filepath.Join(dir, "frames", spoolPrefix+"*"))
+	if len(matches) != 0 {
+		t.Fatalf("rotation left temporary files: %v", matches)
+	}
+}
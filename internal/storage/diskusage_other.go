@@ -0,0 +1,7 @@
+//go:build !linux
+
+package storage
+
+func diskUsage(string) (free, total uint64, err error) {
+	return 0, 0, errDiskUsageUnsupported
+}
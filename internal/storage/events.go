@@ -0,0 +1,54 @@
+package storage
+
+import "sync"
+
+const (
+	// EventFrameSaved is published for every frame written to the index;
+	// duplicates and skipped frames do not raise it.
+	EventFrameSaved = "frame_saved"
+	EventMotion     = "motion"
+)
+
+// Event is published to subscribers once the frame it refers to has been
+// committed and indexed.
+type Event struct {
+	Type  string    `json:"type"`
+	Frame FrameMeta `json:"frame"`
+}
+
+type subscribers struct {
+	mu   sync.Mutex
+	next int
+	fns  map[int]func(Event)
+}
+
+// Subscribe registers fn for events of every type. fn runs synchronously on
+// the uploading goroutine, so it must not block; the returned function
+// removes the subscription.
+func (s *FrameStore) Subscribe(fn func(Event)) func() {
+	s.subs.mu.Lock()
+	defer s.subs.mu.Unlock()
+	if s.subs.fns == nil {
+		s.subs.fns = map[int]func(Event){}
+	}
+	id := s.subs.next
+	s.subs.next++
+	s.subs.fns[id] = fn
+	return func() {
+		s.subs.mu.Lock()
+		defer s.subs.mu.Unlock()
+		delete(s.subs.fns, id)
+	}
+}
+
+func (s *FrameStore) publish(ev Event) {
+	s.subs.mu.Lock()
+	fns := make([]func(Event), 0, len(s.subs.fns))
+	for _, fn := range s.subs.fns {
+		fns = append(fns, fn)
+	}
+	s.subs.mu.Unlock()
+	for _, fn := range fns {
+		fn(ev)
+	}
+}
@@ -0,0 +1,36 @@
+package storage
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestFrameSavedEventOnlyForStoredFrames(t *testing.T) {
+	store := newTestStore(t, t.TempDir())
+	store.Use(NewPHasher(5, true))
+	var saved []FrameMeta
+	store.Subscribe(func(ev Event) {
+		if ev.Type == EventFrameSaved {
+			saved = append(saved, ev.Frame)
+		}
+	})
+
+	first, err := store.SaveFrame(FrameInput{FrameID: "a", IdempotencyKey: "k"}, gradientPNG(t, 0, 200))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// an idempotent retry and a skipped near-duplicate store nothing new
+	if dup, err := store.SaveFrame(FrameInput{FrameID: "a", IdempotencyKey: "k"}, gradientPNG(t, 0, 200)); err != nil || !dup.Duplicate {
+		t.Fatalf("expected duplicate: %+v err=%v", dup, err)
+	}
+	if skipped, err := store.SaveFrame(FrameInput{FrameID: "b"}, gradientPNG(t, 3, 200)); err != nil || !skipped.Skipped {
+		t.Fatalf("expected skipped frame: %+v err=%v", skipped, err)
+	}
+	if _, err := store.SaveFrame(FrameInput{FrameID: "c"}, bytes.NewReader([]byte("not an image"))); err == nil {
+		t.Fatal("expected rejected upload")
+	}
+
+	if len(saved) != 1 || saved[0].FileName != first.FileName || saved[0].SHA256 != first.SHA256 {
+		t.Fatalf("expected a single frame_saved for %s, got %+v", first.FileName, saved)
+	}
+}
@@ -4,31 +4,87 @@ import (
 	"container/list"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
+	"ermete/internal/config"
 	"ermete/internal/observability"
 )
 
 var safeToken = regexp.MustCompile(`[^a-zA-Z0-9._-]`)
 
+const spoolPrefix = ".spool-"
+
+// DefaultMaxImagePixels (40 MP) keeps a decoded frame and its RGBA working
+// copy around 320 MB.
+const DefaultMaxImagePixels = 40_000_000
+
+var (
+	ErrPayloadTooLarge = errors.New("payload too large")
+	ErrInvalidPayload  = errors.New("invalid payload")
+	ErrReadOnly        = errors.New("frame store is read-only")
+)
+
+type IdempotencyConflictError struct {
+	Key            string
+	StoredSHA256   string
+	ReceivedSHA256 string
+}
+
+func (e *IdempotencyConflictError) Error() string {
+	return fmt.Sprintf("idempotency key %q reused with a different payload", e.Key)
+}
+
 type FrameMeta struct {
-	FrameID        string    `json:"frame_id"`
-	Timestamp      string    `json:"timestamp,omitempty"`
-	IdempotencyKey string    `json:"idempotency_key,omitempty"`
-	FileName       string    `json:"file_name"`
-	Path           string    `json:"path"`
-	Size           int64     `json:"size"`
-	ContentType    string    `json:"content_type"`
-	SHA256         string    `json:"sha256"`
-	ReceivedAt     time.Time `json:"received_at"`
-	Duplicate      bool      `json:"duplicate"`
+	FrameID string `json:"frame_id"`
+	// Timestamp is X-Timestamp as sent by the client, CapturedAt the same
+	// instant parsed into UTC and ClockSkewSeconds ReceivedAt minus
+	// CapturedAt. TimestampInvalid flags a value that could not be parsed.
+	Timestamp        string     `json:"timestamp,omitempty"`
+	CapturedAt       *time.Time `json:"captured_at,omitempty"`
+	ClockSkewSeconds *float64   `json:"clock_skew_seconds,omitempty"`
+	TimestampInvalid bool       `json:"timestamp_invalid,omitempty"`
+	IdempotencyKey   string     `json:"idempotency_key,omitempty"`
+	SessionID        string     `json:"session_id,omitempty"`
+	ClientSessionID  string     `json:"client_session_id,omitempty"`
+	DeviceID         string     `json:"device_id,omitempty"`
+	FileName         string     `json:"file_name"`
+	Path             string     `json:"path"`
+	Size             int64      `json:"size"`
+	ContentType      string     `json:"content_type"`
+	Format           string     `json:"format,omitempty"`
+	Width            int        `json:"width,omitempty"`
+	Height           int        `json:"height,omitempty"`
+	SHA256           string     `json:"sha256"`
+	// OriginalSHA256 is the digest of the bytes as uploaded, set with
+	// MetadataStripped when the MetadataStripper stage ran on the frame;
+	// SHA256 and Size always describe the stored bytes.
+	OriginalSHA256    string `json:"original_sha256,omitempty"`
+	MetadataStripped  bool   `json:"metadata_stripped,omitempty"`
+	ThumbnailFileName string `json:"thumbnail_file_name,omitempty"`
+	ThumbnailPath     string `json:"thumbnail_path,omitempty"`
+	// KeyID names the key the frame and its thumbnail are encrypted with at
+	// rest; empty for plaintext frames.
+	KeyID       string    `json:"key_id,omitempty"`
+	PHash       string    `json:"phash,omitempty"`
+	Unchanged   bool      `json:"unchanged,omitempty"`
+	MotionScore *float64  `json:"motion_score,omitempty"`
+	Motion      bool      `json:"motion,omitempty"`
+	ReceivedAt  time.Time `json:"received_at"`
+	Duplicate   bool      `json:"duplicate"`
+	// Skipped reports a frame that a pipeline stage dropped as unchanged;
+	// it has no file and is not indexed.
+	Skipped bool `json:"skipped,omitempty"`
 }
 
 type idemEntry struct {
@@ -37,47 +93,207 @@ type idemEntry struct {
 	elem      *list.Element
 }
 
+type idemRecord struct {
+	Key     string    `json:"key"`
+	SeenAt  time.Time `json:"seen_at,omitempty"`
+	Frame   FrameMeta `json:"frame,omitempty"`
+	Deleted bool      `json:"deleted,omitempty"`
+}
+
 type FrameStore struct {
 	root      string
 	framesDir string
-	mu        sync.Mutex
+	layout    config.StorageLayout
+	backend   Backend
+	readOnly  bool
+	// maintenance leaves idempotency retention to the server
+	maintenance bool
+	maxPixels   int64
+	keys        *Keyring
+	openedAt    time.Time
+	// orphanRecheck is how long Scrub waits before confirming orphans
+	orphanRecheck time.Duration
+	// timestampPolicy decides what happens to unparseable or implausible X-Timestamps
+	timestampPolicy config.TimestampPolicy
+	skewMu          sync.Mutex
+	skewDevices     map[string]*list.Element
+	skewOrder       *list.List
+	health          *healthMonitor
+	mu              sync.Mutex
 
 	byIdempotency map[string]*idemEntry
 	idemOrder     *list.List
 	idemTTL       time.Duration
 	idemMax       int
+	idemJournal   *journal
+	processors    []Processor
+	subs          subscribers
 
-	last  FrameMeta
-	count uint64
+	frames       []*FrameMeta
+	byCapture    []*FrameMeta
+	byName       map[string]*FrameMeta
+	totalBytes   int64
+	indexJournal *journal
 
 	metrics *observability.Metrics
 }
 
+type Options struct {
+	DataDir        string
+	IdempotencyTTL time.Duration
+	IdempotencyMax int
+	Layout         config.StorageLayout
+	Backend        Backend
+	Metrics        *observability.Metrics
+	// MaxImagePixels bounds width*height of accepted frames, since every
+	// pipeline stage decodes the full image; 0 means DefaultMaxImagePixels.
+	MaxImagePixels int64
+	// Keyring enables encryption at rest: new frames are sealed with its
+	// active key, and sealed frames can only be read with their key.
+	Keyring *Keyring
+	// TimestampPolicy rejects or flags frames whose X-Timestamp cannot be
+	// parsed; empty means flag.
+	TimestampPolicy config.TimestampPolicy
+	// ReadOnly opens the index of a store that may be in use by a running
+	// server, for offline tools: nothing is written, migrated or cleaned up.
+	ReadOnly bool
+	// Maintenance opens the store for offline tools that write to it. The
+	// idempotency journal is only appended to: keys are not expired or
+	// evicted and the journal is not compacted, that is left to the server.
+	Maintenance bool
+}
+
 func NewFrameStore(dataDir string, idemTTL time.Duration, idemMax int, metrics *observability.Metrics) (*FrameStore, error) {
+	return OpenFrameStore(Options{DataDir: dataDir, IdempotencyTTL: idemTTL, IdempotencyMax: idemMax, Metrics: metrics})
+}
+
+func OpenFrameStore(opts Options) (*FrameStore, error) {
+	idemTTL, idemMax := opts.IdempotencyTTL, opts.IdempotencyMax
 	if idemTTL <= 0 {
 		idemTTL = 10 * time.Minute
 	}
 	if idemMax <= 0 {
 		idemMax = 50000
 	}
-	framesDir := filepath.Join(dataDir, "frames")
-	if err := os.MkdirAll(framesDir, 0o755); err != nil {
+	if opts.Layout == "" {
+		opts.Layout = config.StorageLayoutFlat
+	}
+	if opts.MaxImagePixels <= 0 {
+		opts.MaxImagePixels = DefaultMaxImagePixels
+	}
+	framesDir := filepath.Join(opts.DataDir, "frames")
+	if opts.ReadOnly {
+		if _, err := os.Stat(framesDir); err != nil {
+			return nil, fmt.Errorf("open frames dir: %w", err)
+		}
+	} else if err := os.MkdirAll(framesDir, 0o755); err != nil {
 		return nil, fmt.Errorf("create frames dir: %w", err)
 	}
+	if opts.Backend == nil {
+		opts.Backend = NewLocalBackend(framesDir)
+	}
 	s := &FrameStore{
-		root:          dataDir,
-		framesDir:     framesDir,
-		byIdempotency: map[string]*idemEntry{},
-		idemOrder:     list.New(),
-		idemTTL:       idemTTL,
-		idemMax:       idemMax,
-		metrics:       metrics,
+		root:            opts.DataDir,
+		framesDir:       framesDir,
+		layout:          opts.Layout,
+		backend:         opts.Backend,
+		readOnly:        opts.ReadOnly,
+		maintenance:     opts.Maintenance,
+		maxPixels:       opts.MaxImagePixels,
+		keys:            opts.Keyring,
+		openedAt:        time.Now(),
+		orphanRecheck:   orphanRecheckDelay,
+		timestampPolicy: opts.TimestampPolicy,
+		skewDevices:     map[string]*list.Element{},
+		skewOrder:       list.New(),
+		byIdempotency:   map[string]*idemEntry{},
+		idemOrder:       list.New(),
+		idemTTL:         idemTTL,
+		idemMax:         idemMax,
+		byName:          map[string]*FrameMeta{},
+		metrics:         opts.Metrics,
+	}
+	if opts.ReadOnly {
+		if err := s.loadIndex(filepath.Join(opts.DataDir, "frames.index")); err != nil {
+			return nil, err
+		}
+		s.idemJournal = &journal{}
+		return s, nil
+	}
+	s.removeStaleSpools()
+	if err := s.loadIndex(filepath.Join(opts.DataDir, "frames.index")); err != nil {
+		return nil, err
+	}
+	if err := s.loadIdempotency(filepath.Join(opts.DataDir, "idempotency.journal")); err != nil {
+		return nil, err
+	}
+	if err := s.migrateLayout(); err != nil {
+		return nil, err
 	}
 	s.updateMetrics()
-	go s.cleanupLoop()
+	if !s.maintenance {
+		go s.cleanupLoop()
+	}
 	return s, nil
 }
 
+func (s *FrameStore) loadIdempotency(path string) error {
+	now := time.Now().UTC()
+	replay := func(line []byte) error {
+		var rec idemRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return err
+		}
+		if rec.Key == "" {
+			return nil
+		}
+		s.removeIdemEntryLocked(rec.Key)
+		if rec.Deleted || !s.maintenance && now.Sub(rec.SeenAt) > s.idemTTL {
+			return nil
+		}
+		s.addIdemEntryLocked(rec.Key, rec.Frame, rec.SeenAt)
+		if !s.maintenance {
+			s.trimIdemLocked(false)
+		}
+		return nil
+	}
+	if s.maintenance {
+		// every live key is kept, so frames removed by the tool get their
+		// deletion journaled whatever the server's TTL
+		j, err := openJournal(path, replay)
+		if err != nil {
+			return fmt.Errorf("replay idempotency journal: %w", err)
+		}
+		s.idemJournal = j
+		return nil
+	}
+	if _, err := replayJournal(path, replay); err != nil {
+		return fmt.Errorf("replay idempotency journal: %w", err)
+	}
+	j, err := createJournal(path, s.idemRecordsLocked())
+	if err != nil {
+		return fmt.Errorf("compact idempotency journal: %w", err)
+	}
+	s.idemJournal = j
+	return nil
+}
+
+func (s *FrameStore) idemRecordsLocked() []any {
+	out := make([]any, 0, len(s.byIdempotency))
+	for e := s.idemOrder.Front(); e != nil; e = e.Next() {
+		key, _ := e.Value.(string)
+		entry := s.byIdempotency[key]
+		out = append(out, idemRecord{Key: key, SeenAt: entry.seenAt, Frame: entry.frameMeta})
+	}
+	return out
+}
+
+func (s *FrameStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return errors.Join(s.idemJournal.close(), s.indexJournal.close())
+}
+
 func (s *FrameStore) cleanupLoop() {
 	ticker := time.NewTicker(1 * time.Minute)
 	defer ticker.Stop()
@@ -94,68 +310,350 @@ func (s *FrameStore) cleanupExpired(now time.Time) {
 			s.removeIdemEntryLocked(k)
 		}
 	}
+	if s.idemJournal.shouldCompact(len(s.byIdempotency)) {
+		// a failed compaction leaves the previous journal in place
+		_ = s.idemJournal.rewrite(s.idemRecordsLocked())
+	}
 	s.updateMetricsLocked()
 }
 
+// IsReady reports whether the frames directory exists and, once
+// StartHealth is running, the last probe found it writable with enough free
+// space.
 func (s *FrameStore) IsReady() bool {
-	_, err := os.Stat(s.framesDir)
-	return err == nil
+	if _, err := os.Stat(s.framesDir); err != nil {
+		return false
+	}
+	return s.Health().OK()
+}
+
+type FrameInput struct {
+	FrameID        string
+	Timestamp      string
+	IdempotencyKey string
+	ContentType    string
+	// SessionID is the server-side WebRTC session active at upload time;
+	// ClientSessionID is an opaque identifier supplied by the client.
+	SessionID       string
+	ClientSessionID string
+	// DeviceID identifies the client device across its sessions.
+	DeviceID string
 }
 
-func (s *FrameStore) SaveFrame(frameID, timestamp, idem, contentType string, payload []byte) (FrameMeta, error) {
-	cleanID := sanitizeToken(frameID)
+type spooledFrame struct {
+	path   string
+	size   int64
+	sha256 string
+}
+
+func (s *FrameStore) SaveFrame(in FrameInput, body io.Reader) (FrameMeta, error) {
+	if s.readOnly {
+		return FrameMeta{}, ErrReadOnly
+	}
+	if err := s.checkHealth(); err != nil {
+		if s.metrics != nil {
+			s.metrics.StorageRejectedTotal.Inc()
+		}
+		return FrameMeta{}, err
+	}
+	if err := ValidateClientSessionID(in.ClientSessionID); err != nil {
+		return FrameMeta{}, err
+	}
+	if err := ValidateDeviceID(in.DeviceID); err != nil {
+		return FrameMeta{}, err
+	}
+	cleanID := sanitizeToken(in.FrameID)
 	if cleanID == "" {
 		cleanID = fmt.Sprintf("frame-%d", time.Now().UnixNano())
 	}
-	if timestamp == "" {
-		timestamp = time.Now().UTC().Format(time.RFC3339Nano)
+	capturedAt, invalidTimestamp, err := s.parseTimestamp(in.Timestamp)
+	if err != nil {
+		return FrameMeta{}, err
+	}
+	if in.Timestamp == "" {
+		in.Timestamp = time.Now().UTC().Format(time.RFC3339Nano)
+	}
+
+	spool, err := s.spool(body)
+	if err != nil {
+		return FrameMeta{}, err
+	}
+	defer os.Remove(spool.path)
+	info, err := detectImageFile(spool.path)
+	if err == nil {
+		err = checkDeclaredType(in.ContentType, info)
+	}
+	if err == nil {
+		err = s.checkPixels(info.Width, info.Height)
+	}
+	if err != nil {
+		return FrameMeta{}, err
+	}
+	if meta, found, err := s.lookupIdempotency(in.IdempotencyKey, spool.sha256); found || err != nil {
+		return meta, err
+	}
+
+	now := time.Now().UTC()
+	name := path.Join(partitionDir(s.layout, now), fmt.Sprintf("%s_%d%s", cleanID, now.UnixNano(), extForFormat(info.Format)))
+	frame := &PendingFrame{
+		Meta: FrameMeta{
+			FrameID:          in.FrameID,
+			Timestamp:        in.Timestamp,
+			CapturedAt:       capturedAt,
+			TimestampInvalid: invalidTimestamp,
+			IdempotencyKey:   in.IdempotencyKey,
+			SessionID:        in.SessionID,
+			ClientSessionID:  in.ClientSessionID,
+			DeviceID:         in.DeviceID,
+			FileName:         name,
+			Path:             s.backend.Location(name),
+			Size:             spool.size,
+			ContentType:      info.MIMEType,
+			Format:           info.Format,
+			Width:            info.Width,
+			Height:           info.Height,
+			SHA256:           spool.sha256,
+		},
+		SpoolPath: spool.path,
+		store:     s,
+	}
+	defer frame.cleanup()
+	for _, p := range s.processors {
+		if err := p.Process(frame); err != nil {
+			return FrameMeta{}, err
+		}
+		if frame.skip {
+			break
+		}
+	}
+	if frame.skip {
+		if s.metrics != nil {
+			s.metrics.FramesSkippedTotal.Inc()
+		}
+		meta := frame.Meta
+		meta.FileName, meta.Path, meta.ThumbnailFileName, meta.ThumbnailPath = "", "", "", ""
+		meta.ReceivedAt = time.Now().UTC()
+		meta.Skipped = true
+		return meta, nil
+	}
+	meta, err := s.commit(frame)
+	if err != nil || meta.Duplicate {
+		return meta, err
+	}
+	s.recordTimestamp(meta)
+	for _, fn := range frame.onCommit {
+		fn(meta)
+	}
+	s.publish(Event{Type: EventFrameSaved, Frame: meta})
+	for _, ev := range frame.events {
+		s.publish(Event{Type: ev, Frame: meta})
+	}
+	return meta, nil
+}
+
+// MaxImagePixels is the largest width*height the store accepts and decodes.
+func (s *FrameStore) MaxImagePixels() int64 {
+	return s.maxPixels
+}
+
+func (s *FrameStore) checkPixels(width, height int) error {
+	if int64(width)*int64(height) > s.maxPixels {
+		return fmt.Errorf("%w: %dx%d image exceeds %d pixels", ErrPayloadTooLarge, width, height, s.maxPixels)
+	}
+	return nil
+}
+
+// uploadSHA256 is the digest of the payload as the client sent it, which
+// idempotent retries are compared against.
+func (m FrameMeta) uploadSHA256() string {
+	if m.OriginalSHA256 != "" {
+		return m.OriginalSHA256
+	}
+	return m.SHA256
+}
+
+func (s *FrameStore) lookupIdempotency(idem, digest string) (FrameMeta, bool, error) {
+	if idem == "" {
+		return FrameMeta{}, false, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lookupIdempotencyLocked(idem, digest, time.Now().UTC())
+}
+
+func (s *FrameStore) lookupIdempotencyLocked(idem, digest string, now time.Time) (FrameMeta, bool, error) {
+	existing, ok := s.byIdempotency[idem]
+	if !ok {
+		return FrameMeta{}, false, nil
+	}
+	if now.Sub(existing.seenAt) > s.idemTTL {
+		s.removeIdemEntryLocked(idem)
+		return FrameMeta{}, false, nil
+	}
+	if stored := existing.frameMeta.uploadSHA256(); stored != digest {
+		if s.metrics != nil {
+			s.metrics.IdempotencyConflictsTotal.Inc()
+		}
+		return FrameMeta{}, true, &IdempotencyConflictError{Key: idem, StoredSHA256: stored, ReceivedSHA256: digest}
+	}
+	meta := existing.frameMeta
+	meta.Duplicate = true
+	return meta, true, nil
+}
+
+// commit hands the frame and its attachments to the backend before taking
+// the lock, so slow remote stores do not serialise uploads, and then
+// publishes the frame in the index. Blobs of an upload that loses an
+// idempotency race are removed again.
+func (s *FrameStore) commit(frame *PendingFrame) (FrameMeta, error) {
+	meta := frame.Meta
+	committed := make([]string, 0, 1+len(frame.attachments))
+	rollback := func() {
+		for _, key := range committed {
+			_ = s.backend.Remove(key)
+		}
+	}
+	if s.keys != nil {
+		meta.KeyID = s.keys.ActiveID()
+		if err := s.keys.sealFile(frame.SpoolPath); err != nil {
+			return FrameMeta{}, err
+		}
+		for _, a := range frame.attachments {
+			if err := s.keys.sealFile(a.tmpPath); err != nil {
+				return FrameMeta{}, err
+			}
+		}
+	}
+	if err := s.backend.Commit(meta.FileName, frame.SpoolPath, meta.ContentType); err != nil {
+		return FrameMeta{}, fmt.Errorf("commit frame: %w", err)
+	}
+	committed = append(committed, meta.FileName)
+	for _, a := range frame.attachments {
+		if err := s.backend.Commit(a.fileName, a.tmpPath, a.contentType); err != nil {
+			rollback()
+			return FrameMeta{}, fmt.Errorf("commit %s: %w", a.fileName, err)
+		}
+		committed = append(committed, a.fileName)
 	}
 
 	now := time.Now().UTC()
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	idem := meta.IdempotencyKey
+	if idem != "" {
+		// a concurrent upload with the same key may have committed while
+		// this one was being processed
+		if existing, found, err := s.lookupIdempotencyLocked(idem, meta.uploadSHA256(), now); found || err != nil {
+			rollback()
+			return existing, err
+		}
+	}
 
+	meta.ReceivedAt = now
+	if meta.CapturedAt != nil {
+		skew := now.Sub(*meta.CapturedAt).Seconds()
+		meta.ClockSkewSeconds = &skew
+	}
+	// the key is persisted first: a frame that is indexed but whose key was
+	// lost would be stored a second time by the client's retry
 	if idem != "" {
-		if existing, ok := s.byIdempotency[idem]; ok && now.Sub(existing.seenAt) <= s.idemTTL {
-			meta := existing.frameMeta
-			meta.Duplicate = true
-			return meta, nil
-		}
-		if existing, ok := s.byIdempotency[idem]; ok && now.Sub(existing.seenAt) > s.idemTTL {
-			s.removeIdemEntryLocked(idem)
-		}
-	}
-
-	ext := extFromContentType(contentType)
-	name := fmt.Sprintf("%s_%d%s", cleanID, time.Now().UnixNano(), ext)
-	fullPath := filepath.Join(s.framesDir, name)
-	if err := os.WriteFile(fullPath, payload, 0o644); err != nil {
-		return FrameMeta{}, fmt.Errorf("write frame: %w", err)
-	}
-	sum := sha256.Sum256(payload)
-	meta := FrameMeta{
-		FrameID:        frameID,
-		Timestamp:      timestamp,
-		IdempotencyKey: idem,
-		FileName:       name,
-		Path:           fullPath,
-		Size:           int64(len(payload)),
-		ContentType:    contentType,
-		SHA256:         hex.EncodeToString(sum[:]),
-		ReceivedAt:     now,
-	}
-	s.last = meta
-	s.count++
+		if err := s.idemJournal.append(idemRecord{Key: idem, SeenAt: now, Frame: meta}); err != nil {
+			rollback()
+			return FrameMeta{}, fmt.Errorf("persist idempotency key: %w", err)
+		}
+	}
+	if err := s.indexJournal.append(indexRecord{Op: "put", Frame: &meta}); err != nil {
+		if idem != "" {
+			_ = s.idemJournal.append(idemRecord{Key: idem, Deleted: true})
+		}
+		rollback()
+		return FrameMeta{}, fmt.Errorf("index frame: %w", err)
+	}
+	s.putIndexLocked(meta)
+	if meta.Unchanged && s.metrics != nil {
+		s.metrics.FramesUnchangedTotal.Inc()
+	}
 	if idem != "" {
 		s.addIdemEntryLocked(idem, meta, now)
+		s.trimIdemLocked(true)
 	}
 	s.updateMetricsLocked()
 	return meta, nil
 }
 
+// spool streams body into a temporary file inside the frames directory,
+// hashing it on the way, so a local commit is a single rename on the same
+// filesystem and a crash never leaves a partially written frame behind.
+func (s *FrameStore) spool(body io.Reader) (spooledFrame, error) {
+	tmp, err := os.CreateTemp(s.framesDir, spoolPrefix+"*")
+	if err != nil {
+		return spooledFrame{}, fmt.Errorf("create spool file: %w", err)
+	}
+	h := sha256.New()
+	src := &errTrackingReader{r: body}
+	n, err := io.Copy(io.MultiWriter(tmp, h), src)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		var mismatch *ChecksumMismatchError
+		switch {
+		case errors.Is(src.err, ErrPayloadTooLarge):
+			return spooledFrame{}, ErrPayloadTooLarge
+		case errors.As(src.err, &mismatch):
+			return spooledFrame{}, mismatch
+		case src.err != nil:
+			return spooledFrame{}, fmt.Errorf("%w: %v", ErrInvalidPayload, src.err)
+		case errors.Is(err, syscall.ENOSPC):
+			return spooledFrame{}, fmt.Errorf("%w: %v", ErrInsufficientStorage, err)
+		default:
+			return spooledFrame{}, fmt.Errorf("write spool file: %w", err)
+		}
+	}
+	return spooledFrame{path: tmp.Name(), size: n, sha256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+type errTrackingReader struct {
+	r   io.Reader
+	err error
+}
+
+func (t *errTrackingReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if err != nil && err != io.EOF {
+		t.err = err
+	}
+	return n, err
+}
+
+func (s *FrameStore) removeStaleSpools() {
+	matches, _ := filepath.Glob(filepath.Join(s.framesDir, spoolPrefix+"*"))
+	for _, m := range matches {
+		_ = os.Remove(m)
+	}
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
 func (s *FrameStore) addIdemEntryLocked(key string, meta FrameMeta, now time.Time) {
 	elem := s.idemOrder.PushBack(key)
 	s.byIdempotency[key] = &idemEntry{frameMeta: meta, seenAt: now, elem: elem}
+}
+
+// trimIdemLocked evicts the oldest keys above idemMax. Journal replay passes
+// count=false so that restarts do not inflate the evictions metric.
+func (s *FrameStore) trimIdemLocked(count bool) {
 	for len(s.byIdempotency) > s.idemMax {
 		front := s.idemOrder.Front()
 		if front == nil {
@@ -163,7 +661,7 @@ func (s *FrameStore) addIdemEntryLocked(key string, meta FrameMeta, now time.Tim
 		}
 		oldKey, _ := front.Value.(string)
 		s.removeIdemEntryLocked(oldKey)
-		if s.metrics != nil {
+		if count && s.metrics != nil {
 			s.metrics.IdempotencyEvictionsTotal.Inc()
 		}
 	}
@@ -183,7 +681,11 @@ func (s *FrameStore) removeIdemEntryLocked(key string) {
 func (s *FrameStore) LastMeta() (FrameMeta, uint64) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	return s.last, s.count
+	n := len(s.frames)
+	if n == 0 {
+		return FrameMeta{}, 0
+	}
+	return *s.frames[n-1], uint64(n)
 }
 
 func (s *FrameStore) IdempotencySize() int {
@@ -205,6 +707,8 @@ func (s *FrameStore) updateMetrics() {
 func (s *FrameStore) updateMetricsLocked() {
 	if s.metrics != nil {
 		s.metrics.IdempotencyEntries.Set(float64(len(s.byIdempotency)))
+		s.metrics.FramesStored.Set(float64(len(s.frames)))
+		s.metrics.FramesStoredBytes.Set(float64(s.totalBytes))
 	}
 }
 
@@ -213,26 +717,49 @@ func sanitizeToken(in string) string {
 	return safeToken.ReplaceAllString(trimmed, "_")
 }
 
-func extFromContentType(ct string) string {
-	ct = strings.ToLower(ct)
-	switch {
-	case strings.Contains(ct, "png"):
-		return ".png"
-	case strings.Contains(ct, "jpeg"), strings.Contains(ct, "jpg"):
-		return ".jpg"
-	default:
-		return ".bin"
+const maxClientSessionIDLen = 128
+
+// ValidateClientSessionID accepts an empty ID or up to 128 characters from
+// the same set allowed in stored file names.
+func ValidateClientSessionID(id string) error {
+	if len(id) > maxClientSessionIDLen || safeToken.MatchString(id) {
+		return fmt.Errorf("%w: invalid client session id", ErrInvalidPayload)
+	}
+	return nil
+}
+
+// ValidateDeviceID applies the client session ID rules to a device ID.
+func ValidateDeviceID(id string) error {
+	if len(id) > maxClientSessionIDLen || safeToken.MatchString(id) {
+		return fmt.Errorf("%w: invalid device id", ErrInvalidPayload)
 	}
+	return nil
 }
 
 func ReadAllLimited(r io.Reader, maxBytes int64) ([]byte, error) {
-	lr := &io.LimitedReader{R: r, N: maxBytes + 1}
-	b, err := io.ReadAll(lr)
-	if err != nil {
-		return nil, err
+	return io.ReadAll(LimitReader(r, maxBytes))
+}
+
+func LimitReader(r io.Reader, maxBytes int64) io.Reader {
+	return &limitedReader{r: r, n: maxBytes}
+}
+
+type limitedReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.n < 0 {
+		return 0, ErrPayloadTooLarge
+	}
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
 	}
-	if int64(len(b)) > maxBytes {
-		return nil, fmt.Errorf("payload too large")
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	if l.n < 0 {
+		return n, ErrPayloadTooLarge
 	}
-	return b, nil
+	return n, err
 }
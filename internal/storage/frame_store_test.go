@@ -1,6 +1,11 @@
 package storage
 
 import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
@@ -9,6 +14,16 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 )
 
+func newTestStore(t *testing.T, dir string) *FrameStore {
+	t.Helper()
+	store, err := NewFrameStore(dir, 10*time.Minute, 100, observability.NewMetrics(prometheus.NewRegistry()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	return store
+}
+
 func TestIdempotencyMaxEntriesEviction(t *testing.T) {
 	metrics := observability.NewMetrics(prometheus.NewRegistry())
 	store, err := NewFrameStore(t.TempDir(), 10*time.Minute, 3, metrics)
@@ -16,7 +31,7 @@ func TestIdempotencyMaxEntriesEviction(t *testing.T) {
 		t.Fatal(err)
 	}
 	for i := 0; i < 5; i++ {
-		_, err := store.SaveFrame("f", "", string(rune('a'+i)), "image/png", []byte("x"))
+		_, err := store.SaveFrame(FrameInput{FrameID: "f", IdempotencyKey: string(rune('a' + i)), ContentType: "image/png"}, bytes.NewReader(testImage(t, "png", "x")))
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -32,7 +47,7 @@ func TestIdempotencyTTLCleanup(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	if _, err := store.SaveFrame("f", "", "idem-key", "image/png", []byte("x")); err != nil {
+	if _, err := store.SaveFrame(FrameInput{FrameID: "f", IdempotencyKey: "idem-key", ContentType: "image/png"}, bytes.NewReader(testImage(t, "png", "x"))); err != nil {
 		t.Fatal(err)
 	}
 	time.Sleep(70 * time.Millisecond)
@@ -41,3 +56,182 @@ func TestIdempotencyTTLCleanup(t *testing.T) {
 		t.Fatalf("expected expired entries to be removed, got %d", got)
 	}
 }
+
+func TestIdempotencySurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFrameStore(dir, 10*time.Minute, 100, observability.NewMetrics(prometheus.NewRegistry()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := store.SaveFrame(FrameInput{FrameID: "f", IdempotencyKey: "idem-key", ContentType: "image/png"}, bytes.NewReader(testImage(t, "png", "x")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := NewFrameStore(dir, 10*time.Minute, 100, observability.NewMetrics(prometheus.NewRegistry()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	again, err := reopened.SaveFrame(FrameInput{FrameID: "f", IdempotencyKey: "idem-key", ContentType: "image/png"}, bytes.NewReader(testImage(t, "png", "x")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !again.Duplicate || again.FileName != first.FileName {
+		t.Fatalf("expected duplicate of %s after restart, got %+v", first.FileName, again)
+	}
+}
+
+func TestIdempotencyReplayHonoursTTL(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFrameStore(dir, 50*time.Millisecond, 100, observability.NewMetrics(prometheus.NewRegistry()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.SaveFrame(FrameInput{FrameID: "f", IdempotencyKey: "idem-key", ContentType: "image/png"}, bytes.NewReader(testImage(t, "png", "x"))); err != nil {
+		t.Fatal(err)
+	}
+	_ = store.Close()
+	time.Sleep(70 * time.Millisecond)
+
+	reopened, err := NewFrameStore(dir, 50*time.Millisecond, 100, observability.NewMetrics(prometheus.NewRegistry()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if got := reopened.IdempotencySize(); got != 0 {
+		t.Fatalf("expected expired keys to be dropped on replay, got %d", got)
+	}
+}
+
+func TestIdempotencyConflictOnDifferentPayload(t *testing.T) {
+	store, err := NewFrameStore(t.TempDir(), 10*time.Minute, 100, observability.NewMetrics(prometheus.NewRegistry()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := store.SaveFrame(FrameInput{FrameID: "f", IdempotencyKey: "idem-key", ContentType: "image/png"}, bytes.NewReader(testImage(t, "png", "x")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.SaveFrame(FrameInput{FrameID: "f", IdempotencyKey: "idem-key", ContentType: "image/png"}, bytes.NewReader(testImage(t, "png", "y")))
+	var conflict *IdempotencyConflictError
+	if !errors.As(err, &conflict) {
+		t.Fatalf("expected conflict error, got %v", err)
+	}
+	if conflict.StoredSHA256 != first.SHA256 || conflict.ReceivedSHA256 == first.SHA256 {
+		t.Fatalf("unexpected conflict hashes: %+v", conflict)
+	}
+}
+
+func TestSaveFrameTooLargeLeavesNoPartialFile(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFrameStore(dir, 10*time.Minute, 100, observability.NewMetrics(prometheus.NewRegistry()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	_, err = store.SaveFrame(FrameInput{FrameID: "f", ContentType: "image/png"}, LimitReader(bytes.NewReader(testImage(t, "png", "0123456789")), 5))
+	if !errors.Is(err, ErrPayloadTooLarge) {
+		t.Fatalf("expected ErrPayloadTooLarge, got %v", err)
+	}
+	entries, err := os.ReadDir(filepath.Join(dir, "frames"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("expected empty frames dir, found %d entries", len(entries))
+	}
+}
+
+func TestSaveFrameConcurrentUploads(t *testing.T) {
+	store, err := NewFrameStore(t.TempDir(), 10*time.Minute, 100, observability.NewMetrics(prometheus.NewRegistry()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := store.SaveFrame(FrameInput{FrameID: "f", IdempotencyKey: "shared", ContentType: "image/png"}, bytes.NewReader(testImage(t, "png", "same")))
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, count := store.LastMeta(); count != 1 {
+		t.Fatalf("expected a single stored frame for a shared idempotency key, got %d", count)
+	}
+}
+
+func counterValue(t *testing.T, reg *prometheus.Registry, name string) float64 {
+	t.Helper()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range families {
+		if f.GetName() == name {
+			return f.GetMetric()[0].GetCounter().GetValue()
+		}
+	}
+	t.Fatalf("metric %s not registered", name)
+	return 0
+}
+
+func TestIdempotencyReplayDoesNotCountEvictions(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFrameStore(dir, 10*time.Minute, 3, observability.NewMetrics(prometheus.NewRegistry()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := store.SaveFrame(FrameInput{FrameID: "f", IdempotencyKey: string(rune('a' + i)), ContentType: "image/png"}, bytes.NewReader(testImage(t, "png", "x"))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = store.Close()
+
+	reg := prometheus.NewRegistry()
+	reopened, err := NewFrameStore(dir, 10*time.Minute, 2, observability.NewMetrics(reg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if got := reopened.IdempotencySize(); got != 2 {
+		t.Fatalf("expected replay to trim to 2 keys, got %d", got)
+	}
+	if got := counterValue(t, reg, "ermete_idempotency_evictions_total"); got != 0 {
+		t.Fatalf("expected no evictions counted on replay, got %v", got)
+	}
+}
+
+func TestIdempotencyJournalFailureStoresNothing(t *testing.T) {
+	dir := t.TempDir()
+	store := newTestStore(t, dir)
+	_ = store.idemJournal.close()
+	store.idemJournal.f, _ = os.Open(os.DevNull)
+	if _, err := store.SaveFrame(FrameInput{FrameID: "f", IdempotencyKey: "k", ContentType: "image/png"}, bytes.NewReader(testImage(t, "png", "x"))); err == nil {
+		t.Fatal("expected error when the idempotency key cannot be persisted")
+	}
+	if _, count := store.LastMeta(); count != 0 {
+		t.Fatalf("expected no indexed frame, got %d", count)
+	}
+	entries, err := os.ReadDir(filepath.Join(dir, "frames"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("expected the frame blob to be rolled back, found %d entries", len(entries))
+	}
+}
@@ -0,0 +1,154 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+var ErrInsufficientStorage = errors.New("insufficient storage")
+
+// errDiskUsageUnsupported is returned by diskUsage on platforms where free
+// space cannot be measured; only writability is checked there.
+var errDiskUsageUnsupported = errors.New("disk usage not supported on this platform")
+
+type HealthPolicy struct {
+	// MinFreeBytes is the free space under DATA_DIR below which the store
+	// stops accepting frames.
+	MinFreeBytes int64
+	Interval     time.Duration
+}
+
+// HealthStatus is the result of the last storage probe. FreeBytes and
+// TotalBytes are 0 when the platform cannot report them.
+type HealthStatus struct {
+	Writable   bool      `json:"writable"`
+	LowSpace   bool      `json:"low_space"`
+	FreeBytes  uint64    `json:"free_bytes"`
+	TotalBytes uint64    `json:"total_bytes"`
+	Error      string    `json:"error,omitempty"`
+	CheckedAt  time.Time `json:"checked_at"`
+}
+
+func (h HealthStatus) OK() bool {
+	return h.Writable && !h.LowSpace
+}
+
+type healthMonitor struct {
+	policy HealthPolicy
+	status atomic.Pointer[HealthStatus]
+}
+
+// StartHealth probes the store once and then every interval. Until it is
+// called the store is assumed healthy.
+func (s *FrameStore) StartHealth(p HealthPolicy) {
+	if s.readOnly {
+		return
+	}
+	if p.Interval <= 0 {
+		p.Interval = 30 * time.Second
+	}
+	s.health = &healthMonitor{policy: p}
+	s.CheckHealth()
+	go func() {
+		ticker := time.NewTicker(p.Interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			s.CheckHealth()
+		}
+	}()
+}
+
+// CheckHealth writes and syncs a probe file in the frames directory and
+// measures the free space of the filesystem holding it.
+func (s *FrameStore) CheckHealth() HealthStatus {
+	if s.health == nil {
+		return HealthStatus{Writable: true, CheckedAt: time.Now().UTC()}
+	}
+	st := HealthStatus{CheckedAt: time.Now().UTC()}
+	if err := s.probeWrite(); err != nil {
+		st.Error = err.Error()
+	} else {
+		st.Writable = true
+	}
+	free, total, err := diskUsage(s.framesDir)
+	switch {
+	case err == nil:
+		st.FreeBytes, st.TotalBytes = free, total
+		st.LowSpace = free == 0 || free < uint64(s.health.policy.MinFreeBytes)
+	case !errors.Is(err, errDiskUsageUnsupported) && st.Error == "":
+		st.Error = err.Error()
+	}
+	s.health.status.Store(&st)
+	s.recordHealth(st)
+	return st
+}
+
+// Health returns the last probe result.
+func (s *FrameStore) Health() HealthStatus {
+	if s.health == nil {
+		return HealthStatus{Writable: true}
+	}
+	if st := s.health.status.Load(); st != nil {
+		return *st
+	}
+	return HealthStatus{}
+}
+
+// RetryAfter is how long a client rejected with ErrInsufficientStorage
+// should wait: the next probe cannot change the answer any sooner.
+func (s *FrameStore) RetryAfter() time.Duration {
+	if s.health == nil {
+		return 0
+	}
+	return s.health.policy.Interval
+}
+
+// checkHealth fails fast with ErrInsufficientStorage instead of letting a
+// write run into a full or read-only disk.
+func (s *FrameStore) checkHealth() error {
+	st := s.Health()
+	switch {
+	case !st.Writable:
+		return fmt.Errorf("%w: frames directory not writable", ErrInsufficientStorage)
+	case st.LowSpace:
+		return fmt.Errorf("%w: %d bytes free", ErrInsufficientStorage, st.FreeBytes)
+	}
+	return nil
+}
+
+func (s *FrameStore) probeWrite() error {
+	f, err := os.CreateTemp(s.framesDir, spoolPrefix+"probe-*")
+	if err != nil {
+		return fmt.Errorf("create probe file: %w", err)
+	}
+	_, err = f.Write([]byte("ok"))
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if rerr := os.Remove(f.Name()); err == nil {
+		err = rerr
+	}
+	if err != nil {
+		return fmt.Errorf("write probe file: %w", err)
+	}
+	return nil
+}
+
+func (s *FrameStore) recordHealth(st HealthStatus) {
+	if s.metrics == nil {
+		return
+	}
+	writable := 0.0
+	if st.Writable {
+		writable = 1
+	}
+	s.metrics.StorageWritable.Set(writable)
+	s.metrics.StorageFreeBytes.Set(float64(st.FreeBytes))
+	s.metrics.StorageTotalBytes.Set(float64(st.TotalBytes))
+}
@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"ermete/internal/observability"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestHealthFreeSpaceThreshold(t *testing.T) {
+	dir := t.TempDir()
+	reg := prometheus.NewRegistry()
+	store, err := OpenFrameStore(Options{DataDir: dir, Metrics: observability.NewMetrics(reg)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	store.StartHealth(HealthPolicy{MinFreeBytes: 1, Interval: time.Hour})
+	if st := store.Health(); !st.OK() || st.FreeBytes == 0 || st.TotalBytes < st.FreeBytes {
+		t.Fatalf("expected a healthy disk: %+v", st)
+	}
+	if !store.IsReady() || gaugeValue(t, reg, "ermete_storage_writable") != 1 {
+		t.Fatal("expected the store to be ready and writable")
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dir, "frames", spoolPrefix+"*")); len(matches) != 0 {
+		t.Fatalf("probe left files behind: %v", matches)
+	}
+
+	store.health.policy.MinFreeBytes = 1 << 62
+	if st := store.CheckHealth(); st.OK() || !st.LowSpace {
+		t.Fatalf("expected low space: %+v", st)
+	}
+	if store.IsReady() {
+		t.Fatal("store reported ready on a full disk")
+	}
+	if _, err := store.SaveFrame(FrameInput{FrameID: "f"}, bytes.NewReader(testImage(t, "png", "f"))); !errors.Is(err, ErrInsufficientStorage) {
+		t.Fatalf("expected ErrInsufficientStorage, got %v", err)
+	}
+	if got := counterValue(t, reg, "ermete_storage_rejected_frames_total"); got != 1 {
+		t.Fatalf("expected 1 rejected frame, got %v", got)
+	}
+	if store.RetryAfter() != time.Hour {
+		t.Fatalf("unexpected retry after %v", store.RetryAfter())
+	}
+}
+
+func TestHealthNotWritable(t *testing.T) {
+	dir := t.TempDir()
+	store := newTestStore(t, dir)
+	store.StartHealth(HealthPolicy{Interval: time.Hour})
+	if !store.IsReady() {
+		t.Fatal("expected the store to be ready")
+	}
+	if err := os.RemoveAll(filepath.Join(dir, "frames")); err != nil {
+		t.Fatal(err)
+	}
+	if st := store.CheckHealth(); st.Writable || st.Error == "" {
+		t.Fatalf("expected an unwritable store: %+v", st)
+	}
+	if _, err := store.SaveFrame(FrameInput{FrameID: "f"}, bytes.NewReader(testImage(t, "png", "f"))); !errors.Is(err, ErrInsufficientStorage) {
+		t.Fatalf("expected ErrInsufficientStorage, got %v", err)
+	}
+}
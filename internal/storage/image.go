@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"mime"
+	"os"
+	"strings"
+)
+
+var ErrUnsupportedMediaType = errors.New("unsupported media type")
+
+type ImageInfo struct {
+	Format   string
+	MIMEType string
+	Width    int
+	Height   int
+}
+
+var imageFormats = []struct {
+	format string
+	mime   string
+	ext    string
+	match  func(head []byte) bool
+	config func(r io.Reader) (image.Config, error)
+}{
+	{"jpeg", "image/jpeg", ".jpg", func(h []byte) bool { return bytes.HasPrefix(h, []byte{0xff, 0xd8, 0xff}) }, jpeg.DecodeConfig},
+	{"png", "image/png", ".png", func(h []byte) bool { return bytes.HasPrefix(h, []byte("\x89PNG\r\n\x1a\n")) }, png.DecodeConfig},
+	{"gif", "image/gif", ".gif", func(h []byte) bool {
+		return bytes.HasPrefix(h, []byte("GIF87a")) || bytes.HasPrefix(h, []byte("GIF89a"))
+	}, gif.DecodeConfig},
+	{"webp", "image/webp", ".webp", func(h []byte) bool { return len(h) >= 12 && string(h[0:4]) == "RIFF" && string(h[8:12]) == "WEBP" }, webpDecodeConfig},
+}
+
+func detectImageFile(path string) (ImageInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return ImageInfo{}, fmt.Errorf("open spool file: %w", err)
+	}
+	defer f.Close()
+	return DetectImage(f)
+}
+
+// DetectImage identifies the image format from its magic bytes and decodes
+// only the header to obtain the dimensions; pixel data is never decoded.
+func DetectImage(r io.Reader) (ImageInfo, error) {
+	head := make([]byte, 32)
+	n, err := io.ReadFull(r, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return ImageInfo{}, err
+	}
+	head = head[:n]
+	for _, f := range imageFormats {
+		if !f.match(head) {
+			continue
+		}
+		cfg, err := f.config(io.MultiReader(bytes.NewReader(head), r))
+		if err != nil {
+			return ImageInfo{}, fmt.Errorf("%w: invalid %s header: %v", ErrUnsupportedMediaType, f.format, err)
+		}
+		if cfg.Width <= 0 || cfg.Height <= 0 {
+			return ImageInfo{}, fmt.Errorf("%w: invalid %s dimensions", ErrUnsupportedMediaType, f.format)
+		}
+		return ImageInfo{Format: f.format, MIMEType: f.mime, Width: cfg.Width, Height: cfg.Height}, nil
+	}
+	return ImageInfo{}, fmt.Errorf("%w: payload is not a JPEG, PNG, GIF or WebP image", ErrUnsupportedMediaType)
+}
+
+func checkDeclaredType(declared string, info ImageInfo) error {
+	media, _, err := mime.ParseMediaType(declared)
+	if err != nil {
+		media = strings.ToLower(strings.TrimSpace(declared))
+	}
+	switch media {
+	case "", "application/octet-stream":
+		return nil
+	case "image/jpg", "image/pjpeg":
+		media = "image/jpeg"
+	}
+	if media != info.MIMEType {
+		return fmt.Errorf("%w: declared %s but payload is %s", ErrUnsupportedMediaType, media, info.MIMEType)
+	}
+	return nil
+}
+
+func extForFormat(format string) string {
+	for _, f := range imageFormats {
+		if f.format == format {
+			return f.ext
+		}
+	}
+	return ".bin"
+}
+
+func webpDecodeConfig(r io.Reader) (image.Config, error) {
+	var hdr [30]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return image.Config{}, err
+	}
+	data := hdr[20:]
+	switch string(hdr[12:16]) {
+	case "VP8 ":
+		if data[3] != 0x9d || data[4] != 0x01 || data[5] != 0x2a {
+			return image.Config{}, errors.New("missing VP8 start code")
+		}
+		w := int(binary.LittleEndian.Uint16(data[6:8]) & 0x3fff)
+		h := int(binary.LittleEndian.Uint16(data[8:10]) & 0x3fff)
+		return image.Config{Width: w, Height: h}, nil
+	case "VP8L":
+		if data[0] != 0x2f {
+			return image.Config{}, errors.New("missing VP8L signature")
+		}
+		bits := binary.LittleEndian.Uint32(data[1:5])
+		return image.Config{Width: int(bits&0x3fff) + 1, Height: int((bits>>14)&0x3fff) + 1}, nil
+	case "VP8X":
+		w := int(uint32(data[4])|uint32(data[5])<<8|uint32(data[6])<<16) + 1
+		h := int(uint32(data[7])|uint32(data[8])<<8|uint32(data[9])<<16) + 1
+		return image.Config{Width: w, Height: h}, nil
+	default:
+		return image.Config{}, errors.New("unknown WebP chunk")
+	}
+}
@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func testImage(t *testing.T, format, seed string) []byte {
+	t.Helper()
+	img := image.NewGray(image.Rect(0, 0, len(seed)+1, 2))
+	for i := 0; i < len(seed); i++ {
+		img.SetGray(i, 0, color.Gray{Y: seed[i]})
+	}
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case "jpeg":
+		err = jpeg.Encode(&buf, img, nil)
+	default:
+		err = png.Encode(&buf, img)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDetectImageFormats(t *testing.T) {
+	info, err := DetectImage(bytes.NewReader(testImage(t, "png", "abc")))
+	if err != nil || info.Format != "png" || info.Width != 4 || info.Height != 2 {
+		t.Fatalf("unexpected png detection: %+v err=%v", info, err)
+	}
+	info, err = DetectImage(bytes.NewReader(testImage(t, "jpeg", "abc")))
+	if err != nil || info.MIMEType != "image/jpeg" || info.Width != 4 {
+		t.Fatalf("unexpected jpeg detection: %+v err=%v", info, err)
+	}
+
+	webp := []byte("RIFF\x00\x00\x00\x00WEBPVP8L\x00\x00\x00\x00\x2f")
+	bits := make([]byte, 4)
+	binary.LittleEndian.PutUint32(bits, uint32(639)|uint32(479)<<14)
+	webp = append(webp, bits...)
+	webp = append(webp, make([]byte, 8)...)
+	info, err = DetectImage(bytes.NewReader(webp))
+	if err != nil || info.Format != "webp" || info.Width != 640 || info.Height != 480 {
+		t.Fatalf("unexpected webp detection: %+v err=%v", info, err)
+	}
+
+	if _, err := DetectImage(bytes.NewReader([]byte("not an image"))); !errors.Is(err, ErrUnsupportedMediaType) {
+		t.Fatalf("expected unsupported media type, got %v", err)
+	}
+	if _, err := DetectImage(bytes.NewReader([]byte("\x89PNG\r\n\x1a\ntruncated"))); !errors.Is(err, ErrUnsupportedMediaType) {
+		t.Fatalf("expected truncated png to be rejected, got %v", err)
+	}
+}
+
+func TestSaveFrameRejectsContentTypeMismatch(t *testing.T) {
+	store := newTestStore(t, t.TempDir())
+	_, err := store.SaveFrame(FrameInput{FrameID: "f", ContentType: "image/jpeg"}, bytes.NewReader(testImage(t, "png", "x")))
+	if !errors.Is(err, ErrUnsupportedMediaType) {
+		t.Fatalf("expected mismatch to be rejected, got %v", err)
+	}
+	meta, err := store.SaveFrame(FrameInput{FrameID: "f", ContentType: "application/octet-stream"}, bytes.NewReader(testImage(t, "jpeg", "x")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.ContentType != "image/jpeg" || meta.Format != "jpeg" || meta.Width != 2 || meta.Height != 2 {
+		t.Fatalf("unexpected detected metadata: %+v", meta)
+	}
+}
+
+// pngHeader returns a PNG signature and IHDR chunk declaring w x h pixels,
+// without any image data.
+func pngHeader(w, h uint32) []byte {
+	ihdr := make([]byte, 17)
+	copy(ihdr, "IHDR")
+	binary.BigEndian.PutUint32(ihdr[4:], w)
+	binary.BigEndian.PutUint32(ihdr[8:], h)
+	ihdr[12], ihdr[13] = 8, 6 // 8-bit RGBA
+	out := []byte("\x89PNG\r\n\x1a\n")
+	out = binary.BigEndian.AppendUint32(out, 13)
+	out = append(out, ihdr...)
+	return binary.BigEndian.AppendUint32(out, crc32.ChecksumIEEE(ihdr))
+}
+
+func TestSaveFrameRejectsOversizedImage(t *testing.T) {
+	dir := t.TempDir()
+	store := newTestStore(t, dir)
+	_, err := store.SaveFrame(FrameInput{FrameID: "bomb", ContentType: "image/png"}, bytes.NewReader(pngHeader(40000, 40000)))
+	if !errors.Is(err, ErrPayloadTooLarge) {
+		t.Fatalf("expected oversized image to be rejected, got %v", err)
+	}
+	if _, count := store.LastMeta(); count != 0 {
+		t.Fatalf("expected nothing stored, got %d frames", count)
+	}
+}
@@ -0,0 +1,405 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+	ErrFrameNotFound = errors.New("frame not found")
+)
+
+type TimeField string
+
+const (
+	TimeFieldReceived  TimeField = "received"
+	TimeFieldTimestamp TimeField = "timestamp"
+)
+
+const (
+	DefaultListLimit = 100
+	MaxListLimit     = 1000
+)
+
+type FrameQuery struct {
+	Since         time.Time
+	Until         time.Time
+	TimeField     TimeField
+	FrameIDPrefix string
+	ContentType   string
+	// SessionID matches either the server or the client session ID.
+	SessionID string
+	Partition string
+	Cursor    string
+	Limit     int
+}
+
+type FramePage struct {
+	Frames     []FrameMeta `json:"frames"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+}
+
+type indexRecord struct {
+	Op       string     `json:"op"`
+	Frame    *FrameMeta `json:"frame,omitempty"`
+	FileName string     `json:"file_name,omitempty"`
+}
+
+func (s *FrameStore) loadIndex(path string) error {
+	open := openJournal
+	if s.readOnly {
+		// a journal without a file refuses every write
+		open = func(path string, fn func([]byte) error) (*journal, error) {
+			_, err := replayJournal(path, fn)
+			return &journal{path: path}, err
+		}
+	}
+	_, statErr := os.Stat(path)
+	j, err := open(path, func(line []byte) error {
+		var rec indexRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return err
+		}
+		switch {
+		case rec.Op == "put" && rec.Frame != nil:
+			s.putIndexLocked(*rec.Frame)
+		case rec.Op == "del":
+			s.removeIndexLocked(rec.FileName)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("load frame index: %w", err)
+	}
+	s.indexJournal = j
+	if errors.Is(statErr, os.ErrNotExist) && !s.readOnly {
+		if err := s.backfillIndexLocked(); err != nil {
+			return fmt.Errorf("backfill frame index: %w", err)
+		}
+	}
+	return nil
+}
+
+// backfillIndexLocked indexes the frames written before the index existed,
+// so a store upgraded in place keeps its data listable, downloadable and
+// subject to retention. Names follow <frame_id>_<unix_nanos><ext>; other
+// files fall back to their modification time. Only the local backend is
+// scanned: a remote bucket is never listed.
+func (s *FrameStore) backfillIndexLocked() error {
+	if _, ok := s.backend.(*LocalBackend); !ok {
+		return nil
+	}
+	return filepath.WalkDir(s.framesDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name := d.Name()
+		if d.IsDir() && strings.HasPrefix(name, ".") && p != s.framesDir {
+			return fs.SkipDir
+		}
+		if d.IsDir() || strings.HasPrefix(name, ".") || strings.HasSuffix(name, thumbnailSuffix) {
+			return nil
+		}
+		rel, err := filepath.Rel(s.framesDir, p)
+		if err != nil {
+			return err
+		}
+		meta, ok, err := backfillMeta(p, filepath.ToSlash(rel))
+		if err != nil || !ok {
+			return err
+		}
+		meta.Path = s.backend.Location(meta.FileName)
+		if _, err := os.Stat(p + thumbnailSuffix); err == nil {
+			meta.ThumbnailFileName = meta.FileName + thumbnailSuffix
+			meta.ThumbnailPath = s.backend.Location(meta.ThumbnailFileName)
+		}
+		if err := s.indexJournal.append(indexRecord{Op: "put", Frame: &meta}); err != nil {
+			return err
+		}
+		s.putIndexLocked(meta)
+		return nil
+	})
+}
+
+// backfillMeta describes an existing frame file; files that are not
+// recognised images are skipped.
+func backfillMeta(p, fileName string) (FrameMeta, bool, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return FrameMeta{}, false, err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return FrameMeta{}, false, err
+	}
+	info, err := DetectImage(f)
+	if err != nil {
+		return FrameMeta{}, false, nil
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return FrameMeta{}, false, err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return FrameMeta{}, false, err
+	}
+	base := strings.TrimSuffix(path.Base(fileName), path.Ext(fileName))
+	frameID, received := base, st.ModTime().UTC()
+	if i := strings.LastIndexByte(base, '_'); i > 0 {
+		if nanos, err := strconv.ParseInt(base[i+1:], 10, 64); err == nil {
+			frameID, received = base[:i], time.Unix(0, nanos).UTC()
+		}
+	}
+	return FrameMeta{
+		FrameID:     frameID,
+		Timestamp:   received.Format(time.RFC3339Nano),
+		FileName:    fileName,
+		Size:        st.Size(),
+		ContentType: info.MIMEType,
+		Format:      info.Format,
+		Width:       info.Width,
+		Height:      info.Height,
+		SHA256:      hex.EncodeToString(h.Sum(nil)),
+		ReceivedAt:  received,
+	}, true, nil
+}
+
+func (s *FrameStore) putIndexLocked(meta FrameMeta) {
+	meta.Duplicate = false
+	if existing, ok := s.byName[meta.FileName]; ok {
+		if existing.ReceivedAt.Equal(meta.ReceivedAt) && existing.CaptureTime().Equal(meta.CaptureTime()) {
+			s.totalBytes += meta.Size - existing.Size
+			*existing = meta
+			return
+		}
+		s.removeIndexLocked(meta.FileName)
+	}
+	m := &meta
+	s.byName[meta.FileName] = m
+	s.totalBytes += meta.Size
+	s.frames = insertOrdered(s.frames, m, byReceived)
+	s.byCapture = insertOrdered(s.byCapture, m, byCapture)
+}
+
+func (s *FrameStore) removeIndexLocked(fileName string) {
+	m, ok := s.byName[fileName]
+	if !ok {
+		return
+	}
+	delete(s.byName, fileName)
+	s.totalBytes -= m.Size
+	s.frames = removeOrdered(s.frames, m, byReceived)
+	s.byCapture = removeOrdered(s.byCapture, m, byCapture)
+}
+
+// frameOrder sorts frames by one of their times, then by file name.
+type frameOrder func(m *FrameMeta) time.Time
+
+var (
+	byReceived frameOrder = func(m *FrameMeta) time.Time { return m.ReceivedAt }
+	byCapture  frameOrder = func(m *FrameMeta) time.Time { return m.CaptureTime() }
+)
+
+func (o frameOrder) less(a, b *FrameMeta) bool {
+	ta, tb := o(a), o(b)
+	if !ta.Equal(tb) {
+		return ta.Before(tb)
+	}
+	return a.FileName < b.FileName
+}
+
+func insertOrdered(frames []*FrameMeta, m *FrameMeta, o frameOrder) []*FrameMeta {
+	i := sort.Search(len(frames), func(i int) bool { return o.less(m, frames[i]) })
+	frames = append(frames, nil)
+	copy(frames[i+1:], frames[i:])
+	frames[i] = m
+	return frames
+}
+
+func removeOrdered(frames []*FrameMeta, m *FrameMeta, o frameOrder) []*FrameMeta {
+	i := sort.Search(len(frames), func(i int) bool { return !o.less(frames[i], m) })
+	if i < len(frames) && frames[i] == m {
+		return append(frames[:i], frames[i+1:]...)
+	}
+	return frames
+}
+
+func (s *FrameStore) indexRecordsLocked() []any {
+	out := make([]any, 0, len(s.frames))
+	for _, m := range s.frames {
+		out = append(out, indexRecord{Op: "put", Frame: m})
+	}
+	return out
+}
+
+func (s *FrameStore) Frame(fileName string) (FrameMeta, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.byName[fileName]
+	if !ok {
+		return FrameMeta{}, false
+	}
+	return *m, true
+}
+
+func (s *FrameStore) OpenFrame(fileName string) (FrameMeta, io.ReadSeekCloser, error) {
+	meta, ok := s.Frame(fileName)
+	if !ok {
+		return FrameMeta{}, nil, ErrFrameNotFound
+	}
+	f, err := s.openBlob(meta.FileName)
+	return meta, f, err
+}
+
+func (s *FrameStore) OpenThumbnail(fileName string) (FrameMeta, io.ReadSeekCloser, error) {
+	meta, ok := s.Frame(fileName)
+	if !ok || meta.ThumbnailFileName == "" {
+		return FrameMeta{}, nil, ErrFrameNotFound
+	}
+	f, err := s.openBlob(meta.ThumbnailFileName)
+	return meta, f, err
+}
+
+func (s *FrameStore) ListFrames(q FrameQuery) (FramePage, error) {
+	if q.Limit <= 0 {
+		q.Limit = DefaultListLimit
+	}
+	if q.Limit > MaxListLimit {
+		q.Limit = MaxListLimit
+	}
+	if q.TimeField == "" {
+		q.TimeField = TimeFieldReceived
+	}
+	after, err := decodeCursor(q.Cursor)
+	if err != nil {
+		return FramePage{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	frames, order := s.frames, byReceived
+	if q.TimeField == TimeFieldTimestamp {
+		frames, order = s.byCapture, byCapture
+	}
+	start := 0
+	if after != nil {
+		start = sort.Search(len(frames), func(i int) bool { return order.less(after, frames[i]) })
+	}
+	if !q.Since.IsZero() {
+		since := cursorFrame(q.Since, "")
+		if i := sort.Search(len(frames), func(i int) bool { return !order.less(frames[i], since) }); i > start {
+			start = i
+		}
+	}
+
+	page := FramePage{Frames: []FrameMeta{}}
+	for i := start; i < len(frames); i++ {
+		m := frames[i]
+		if !q.Until.IsZero() && order(m).After(q.Until) {
+			break
+		}
+		if !q.matches(m) {
+			continue
+		}
+		if len(page.Frames) == q.Limit {
+			last := page.Frames[len(page.Frames)-1]
+			page.NextCursor = encodeCursor(order(&last), last.FileName)
+			break
+		}
+		page.Frames = append(page.Frames, *m)
+	}
+	return page, nil
+}
+
+// WalkFrames calls fn for every frame matching q in index order, paging
+// through ListFrames. q.Limit caps the total number of frames, 0 means all;
+// q.Cursor is honoured as the starting point.
+func (s *FrameStore) WalkFrames(q FrameQuery, fn func(FrameMeta) error) error {
+	remaining := q.Limit
+	for {
+		q.Limit = MaxListLimit
+		if remaining > 0 && remaining < MaxListLimit {
+			q.Limit = remaining
+		}
+		page, err := s.ListFrames(q)
+		if err != nil {
+			return err
+		}
+		for _, m := range page.Frames {
+			if err := fn(m); err != nil {
+				return err
+			}
+		}
+		if remaining > 0 {
+			if remaining -= len(page.Frames); remaining <= 0 {
+				return nil
+			}
+		}
+		if page.NextCursor == "" {
+			return nil
+		}
+		q.Cursor = page.NextCursor
+	}
+}
+
+func (q FrameQuery) matches(m *FrameMeta) bool {
+	if q.FrameIDPrefix != "" && !strings.HasPrefix(m.FrameID, q.FrameIDPrefix) {
+		return false
+	}
+	if q.ContentType != "" && !strings.EqualFold(m.ContentType, q.ContentType) {
+		return false
+	}
+	if q.SessionID != "" && m.SessionID != q.SessionID && m.ClientSessionID != q.SessionID {
+		return false
+	}
+	if p := strings.Trim(q.Partition, "/"); p != "" && !strings.HasPrefix(m.FileName, p+"/") {
+		return false
+	}
+	return true
+}
+
+// encodeCursor records the position after a frame in either ordering: the
+// time it is sorted by and its file name.
+func encodeCursor(at time.Time, fileName string) string {
+	raw := strconv.FormatInt(at.UnixNano(), 10) + "|" + fileName
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// cursorFrame is a probe that sorts at the given time in both orderings.
+func cursorFrame(at time.Time, fileName string) *FrameMeta {
+	return &FrameMeta{ReceivedAt: at, CapturedAt: &at, FileName: fileName}
+}
+
+func decodeCursor(cursor string) (*FrameMeta, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	nanos, name, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return nil, ErrInvalidCursor
+	}
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return cursorFrame(time.Unix(0, n).UTC(), name), nil
+}
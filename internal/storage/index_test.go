@@ -0,0 +1,219 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"ermete/internal/observability"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestListFramesPaginationAndFilters(t *testing.T) {
+	store, err := NewFrameStore(t.TempDir(), 10*time.Minute, 100, observability.NewMetrics(prometheus.NewRegistry()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	for i := 0; i < 5; i++ {
+		ct, format := "image/png", "png"
+		if i%2 == 1 {
+			ct, format = "image/jpeg", "jpeg"
+		}
+		if _, err := store.SaveFrame(FrameInput{FrameID: fmt.Sprintf("cam-%d", i), ContentType: ct, SessionID: "sess-1"}, bytes.NewReader(testImage(t, format, string(rune('a'+i))))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := store.SaveFrame(FrameInput{FrameID: "other", ContentType: "image/png", SessionID: "sess-2"}, bytes.NewReader(testImage(t, "png", "z"))); err != nil {
+		t.Fatal(err)
+	}
+
+	var seen []string
+	cursor := ""
+	for {
+		page, err := store.ListFrames(FrameQuery{FrameIDPrefix: "cam-", Limit: 2, Cursor: cursor})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range page.Frames {
+			seen = append(seen, f.FrameID)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+	if len(seen) != 5 || seen[0] != "cam-0" || seen[4] != "cam-4" {
+		t.Fatalf("unexpected paginated frames: %v", seen)
+	}
+
+	page, err := store.ListFrames(FrameQuery{ContentType: "image/jpeg"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Frames) != 2 {
+		t.Fatalf("expected 2 jpeg frames, got %d", len(page.Frames))
+	}
+	page, err = store.ListFrames(FrameQuery{SessionID: "sess-2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Frames) != 1 || page.Frames[0].FrameID != "other" {
+		t.Fatalf("unexpected session frames: %+v", page.Frames)
+	}
+	page, err = store.ListFrames(FrameQuery{Since: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Frames) != 0 {
+		t.Fatalf("expected no frames in the future, got %d", len(page.Frames))
+	}
+	if _, err := store.ListFrames(FrameQuery{Cursor: "%%%"}); err != ErrInvalidCursor {
+		t.Fatalf("expected invalid cursor error, got %v", err)
+	}
+}
+
+func TestListFramesByClientTimestamp(t *testing.T) {
+	store, err := NewFrameStore(t.TempDir(), 10*time.Minute, 100, observability.NewMetrics(prometheus.NewRegistry()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if _, err := store.SaveFrame(FrameInput{FrameID: "old", Timestamp: "2020-01-01T00:00:00Z", ContentType: "image/png"}, bytes.NewReader(testImage(t, "png", "a"))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.SaveFrame(FrameInput{FrameID: "new", Timestamp: "2026-01-01T00:00:00Z", ContentType: "image/png"}, bytes.NewReader(testImage(t, "png", "b"))); err != nil {
+		t.Fatal(err)
+	}
+	since, _ := time.Parse(time.RFC3339, "2025-01-01T00:00:00Z")
+	page, err := store.ListFrames(FrameQuery{TimeField: TimeFieldTimestamp, Since: since})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Frames) != 1 || page.Frames[0].FrameID != "new" {
+		t.Fatalf("unexpected frames: %+v", page.Frames)
+	}
+}
+
+func TestFrameIndexSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFrameStore(dir, 10*time.Minute, 100, observability.NewMetrics(prometheus.NewRegistry()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved, err := store.SaveFrame(FrameInput{FrameID: "f", ContentType: "image/png", SessionID: "sess-1", ClientSessionID: "call-1"}, bytes.NewReader(testImage(t, "png", "x")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = store.Close()
+
+	reopened, err := NewFrameStore(dir, 10*time.Minute, 100, observability.NewMetrics(prometheus.NewRegistry()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	got, ok := reopened.Frame(saved.FileName)
+	if !ok || got.SHA256 != saved.SHA256 || got.SessionID != "sess-1" || got.ClientSessionID != "call-1" {
+		t.Fatalf("expected indexed frame after restart, got %+v", got)
+	}
+	for _, id := range []string{"sess-1", "call-1"} {
+		if page, err := reopened.ListFrames(FrameQuery{SessionID: id}); err != nil || len(page.Frames) != 1 {
+			t.Fatalf("expected frame listed for session %s, got %+v err=%v", id, page.Frames, err)
+		}
+	}
+	if last, count := reopened.LastMeta(); count != 1 || last.FileName != saved.FileName {
+		t.Fatalf("unexpected last meta after restart: %+v count=%d", last, count)
+	}
+}
+
+func TestWalkFramesAndReadOnlyStore(t *testing.T) {
+	dir := t.TempDir()
+	store := newTestStore(t, dir)
+	for i := 0; i < 5; i++ {
+		if _, err := store.SaveFrame(FrameInput{FrameID: fmt.Sprintf("cam-%d", i)}, bytes.NewReader(testImage(t, "png", string(rune('a'+i))))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var ids []string
+	if err := store.WalkFrames(FrameQuery{Limit: 3}, func(m FrameMeta) error {
+		ids = append(ids, m.FrameID)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 3 || ids[0] != "cam-0" {
+		t.Fatalf("unexpected walk: %v", ids)
+	}
+
+	ro, err := OpenFrameStore(Options{DataDir: dir, ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ro.Close()
+	if _, count := ro.LastMeta(); count != 5 {
+		t.Fatalf("expected 5 frames in read-only store, got %d", count)
+	}
+	if _, err := ro.SaveFrame(FrameInput{}, bytes.NewReader(testImage(t, "png", "x"))); err != ErrReadOnly {
+		t.Fatalf("expected ErrReadOnly, got %v", err)
+	}
+	if _, err := store.SaveFrame(FrameInput{FrameID: "after"}, bytes.NewReader(testImage(t, "png", "after"))); err != nil {
+		t.Fatalf("read-only open must not disturb the live store: %v", err)
+	}
+}
+
+// writeLegacyFrames lays out frames the way releases without an index did:
+// <frame_id>_<unix_nanos><ext> directly under DATA_DIR/frames.
+func writeLegacyFrames(t *testing.T, dir string, received ...time.Time) []string {
+	t.Helper()
+	framesDir := filepath.Join(dir, "frames")
+	if err := os.MkdirAll(framesDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0, len(received))
+	for i, at := range received {
+		name := fmt.Sprintf("cam-%d_%d.png", i, at.UnixNano())
+		if err := os.WriteFile(filepath.Join(framesDir, name), testImage(t, "png", fmt.Sprint(i)), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+func TestIndexBackfillsExistingFrames(t *testing.T) {
+	dir := t.TempDir()
+	at := time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC)
+	names := writeLegacyFrames(t, dir, at, at.Add(time.Minute))
+	if err := os.WriteFile(filepath.Join(dir, "frames", "notes.txt"), []byte("not a frame"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	store := newTestStore(t, dir)
+	page, err := store.ListFrames(FrameQuery{})
+	if err != nil || len(page.Frames) != 2 {
+		t.Fatalf("expected 2 backfilled frames, got %+v err=%v", page.Frames, err)
+	}
+	first := page.Frames[0]
+	if first.FileName != names[0] || first.FrameID != "cam-0" || !first.ReceivedAt.Equal(at) || first.Format != "png" || first.SHA256 == "" {
+		t.Fatalf("unexpected backfilled metadata: %+v", first)
+	}
+	if _, f, err := store.OpenFrame(names[1]); err != nil {
+		t.Fatal(err)
+	} else {
+		f.Close()
+	}
+	_ = store.Close()
+
+	// the backfill runs only when no index exists
+	if err := os.Remove(filepath.Join(dir, "frames", names[1])); err != nil {
+		t.Fatal(err)
+	}
+	reopened := newTestStore(t, dir)
+	if _, count := reopened.LastMeta(); count != 2 {
+		t.Fatalf("expected the journaled index to be reused, got %d frames", count)
+	}
+}
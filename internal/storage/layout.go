@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"fmt"
+	"path"
+	"time"
+
+	"ermete/internal/config"
+)
+
+func partitionDir(layout config.StorageLayout, t time.Time) string {
+	t = t.UTC()
+	switch layout {
+	case config.StorageLayoutDaily:
+		return t.Format("2006/01/02")
+	case config.StorageLayoutHourly:
+		return t.Format("2006/01/02/15")
+	default:
+		return ""
+	}
+}
+
+// migrateLayout moves every indexed frame whose directory does not match the
+// configured layout, so switching STORAGE_LAYOUT reorganises existing data
+// on the next start. A legacy flat directory without an index is covered
+// because loadIndex backfills it first.
+func (s *FrameStore) migrateLayout() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var pending []FrameMeta
+	for _, m := range s.frames {
+		if path.Dir(m.FileName) != dirOrDot(partitionDir(s.layout, m.ReceivedAt)) {
+			pending = append(pending, *m)
+		}
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+	renamed := make(map[string]FrameMeta, len(pending))
+	for _, old := range pending {
+		moved, err := s.moveFrameLocked(old)
+		if err != nil {
+			return fmt.Errorf("migrate %s: %w", old.FileName, err)
+		}
+		renamed[old.FileName] = moved
+	}
+	for key, entry := range s.byIdempotency {
+		moved, ok := renamed[entry.frameMeta.FileName]
+		if !ok {
+			continue
+		}
+		entry.frameMeta = moved
+		if err := s.idemJournal.append(idemRecord{Key: key, SeenAt: entry.seenAt, Frame: moved}); err != nil {
+			return fmt.Errorf("migrate idempotency key: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *FrameStore) moveFrameLocked(old FrameMeta) (FrameMeta, error) {
+	moved := old
+	dir := partitionDir(s.layout, old.ReceivedAt)
+	moved.FileName = path.Join(dir, path.Base(old.FileName))
+	moved.Path = s.backend.Location(moved.FileName)
+	if old.ThumbnailFileName != "" {
+		moved.ThumbnailFileName = path.Join(dir, path.Base(old.ThumbnailFileName))
+		moved.ThumbnailPath = s.backend.Location(moved.ThumbnailFileName)
+	}
+	if err := s.backend.Rename(old.FileName, moved.FileName); err != nil {
+		return FrameMeta{}, err
+	}
+	if old.ThumbnailFileName != "" {
+		if err := s.backend.Rename(old.ThumbnailFileName, moved.ThumbnailFileName); err != nil {
+			return FrameMeta{}, err
+		}
+	}
+	if err := s.indexJournal.append(indexRecord{Op: "put", Frame: &moved}); err != nil {
+		return FrameMeta{}, err
+	}
+	if err := s.indexJournal.append(indexRecord{Op: "del", FileName: old.FileName}); err != nil {
+		return FrameMeta{}, err
+	}
+	s.removeIndexLocked(old.FileName)
+	s.putIndexLocked(moved)
+	return moved, nil
+}
+
+func dirOrDot(dir string) string {
+	if dir == "" {
+		return "."
+	}
+	return dir
+}
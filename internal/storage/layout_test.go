@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"ermete/internal/config"
+	"ermete/internal/observability"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func openLayoutStore(t *testing.T, dir string, layout config.StorageLayout) *FrameStore {
+	t.Helper()
+	store, err := OpenFrameStore(Options{DataDir: dir, IdempotencyTTL: 10 * time.Minute, IdempotencyMax: 100, Layout: layout, Metrics: observability.NewMetrics(prometheus.NewRegistry())})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestHourlyLayoutPartitionsFrames(t *testing.T) {
+	store := openLayoutStore(t, t.TempDir(), config.StorageLayoutHourly)
+	defer store.Close()
+	meta, err := store.SaveFrame(FrameInput{FrameID: "f", ContentType: "image/png"}, bytes.NewReader(testImage(t, "png", "x")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := meta.ReceivedAt.Format("2006/01/02/15") + "/"
+	if !strings.HasPrefix(meta.FileName, want) {
+		t.Fatalf("expected file name under %s, got %s", want, meta.FileName)
+	}
+	if _, err := os.Stat(meta.Path); err != nil {
+		t.Fatal(err)
+	}
+	page, err := store.ListFrames(FrameQuery{Partition: meta.ReceivedAt.Format("2006/01/02")})
+	if err != nil || len(page.Frames) != 1 {
+		t.Fatalf("expected frame in daily partition, got %+v err=%v", page.Frames, err)
+	}
+	page, err = store.ListFrames(FrameQuery{Partition: "1999/01/01"})
+	if err != nil || len(page.Frames) != 0 {
+		t.Fatalf("expected empty partition, got %+v err=%v", page.Frames, err)
+	}
+
+	if _, err := store.ApplyRetention(RetentionPolicy{MaxFiles: 0, MaxAge: time.Minute}, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Dir(meta.Path)); !os.IsNotExist(err) {
+		t.Fatalf("expected empty partition dir to be pruned, err=%v", err)
+	}
+}
+
+func TestMigrateFlatLayoutToDaily(t *testing.T) {
+	dir := t.TempDir()
+	flat := openLayoutStore(t, dir, config.StorageLayoutFlat)
+	old, err := flat.SaveFrame(FrameInput{FrameID: "f", IdempotencyKey: "k", ContentType: "image/png"}, bytes.NewReader(testImage(t, "png", "x")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(old.FileName, "/") {
+		t.Fatalf("flat layout must not partition: %s", old.FileName)
+	}
+	_ = flat.Close()
+
+	daily := openLayoutStore(t, dir, config.StorageLayoutDaily)
+	defer daily.Close()
+	moved, ok := daily.Frame(old.ReceivedAt.Format("2006/01/02") + "/" + old.FileName)
+	if !ok {
+		t.Fatal("expected frame to be re-indexed under its daily partition")
+	}
+	if _, err := os.Stat(moved.Path); err != nil {
+		t.Fatalf("expected migrated file: %v", err)
+	}
+	if _, err := os.Stat(old.Path); !os.IsNotExist(err) {
+		t.Fatalf("expected flat file to be moved, err=%v", err)
+	}
+	if _, ok := daily.Frame(old.FileName); ok {
+		t.Fatal("old flat name must be dropped from the index")
+	}
+	dup, err := daily.SaveFrame(FrameInput{FrameID: "f", IdempotencyKey: "k", ContentType: "image/png"}, bytes.NewReader(testImage(t, "png", "x")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !dup.Duplicate || dup.FileName != moved.FileName {
+		t.Fatalf("expected duplicate pointing at migrated frame, got %+v", dup)
+	}
+}
+
+func TestMigrateUnindexedFlatDirectory(t *testing.T) {
+	dir := t.TempDir()
+	at := time.Date(2025, 6, 1, 10, 30, 0, 0, time.UTC)
+	names := writeLegacyFrames(t, dir, at, at.Add(24*time.Hour))
+
+	store := openLayoutStore(t, dir, config.StorageLayoutDaily)
+	defer store.Close()
+	page, err := store.ListFrames(FrameQuery{})
+	if err != nil || len(page.Frames) != 2 {
+		t.Fatalf("expected 2 migrated frames, got %+v err=%v", page.Frames, err)
+	}
+	for i, want := range []string{"2025/06/01/" + names[0], "2025/06/02/" + names[1]} {
+		if page.Frames[i].FileName != want {
+			t.Fatalf("expected %s, got %s", want, page.Frames[i].FileName)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "frames", filepath.FromSlash(want))); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "frames", names[i])); !os.IsNotExist(err) {
+			t.Fatalf("expected flat file %s to be moved, err=%v", names[i], err)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"image"
+	"image/draw"
+	"sync"
+)
+
+const (
+	motionGridW = 32
+	motionGridH = 24
+)
+
+// MotionDetector scores each decodable frame by the mean absolute luma
+// difference from the previous stored one, sampled on a 32x24 grid and
+// scaled to [0, 1]. Frames scoring at least Threshold are flagged and raise
+// an EventMotion.
+type MotionDetector struct {
+	Threshold float64
+
+	mu   sync.Mutex
+	prev []uint8
+}
+
+func NewMotionDetector(threshold float64) *MotionDetector {
+	return &MotionDetector{Threshold: threshold}
+}
+
+func (m *MotionDetector) Process(f *PendingFrame) error {
+	img, err := f.Image()
+	if err != nil {
+		return nil
+	}
+	grid := lumaGrid(img, motionGridW, motionGridH)
+	// the reference only moves once the frame is committed
+	f.OnCommit(func(FrameMeta) {
+		m.mu.Lock()
+		m.prev = grid
+		m.mu.Unlock()
+	})
+
+	m.mu.Lock()
+	prev := m.prev
+	m.mu.Unlock()
+	if prev == nil {
+		return nil
+	}
+	score := motionScore(prev, grid)
+	f.Meta.MotionScore = &score
+	if f.store.metrics != nil {
+		f.store.metrics.MotionScore.Observe(score)
+	}
+	if score >= m.Threshold {
+		f.Meta.Motion = true
+		f.Emit(EventMotion)
+	}
+	return nil
+}
+
+func motionScore(a, b []uint8) float64 {
+	var sum int
+	for i := range a {
+		d := int(a[i]) - int(b[i])
+		if d < 0 {
+			d = -d
+		}
+		sum += d
+	}
+	return float64(sum) / float64(len(a)*255)
+}
+
+// lumaGrid box-filters img down to w x h and returns the Rec. 601 luma of
+// each cell.
+func lumaGrid(img image.Image, w, h int) []uint8 {
+	b := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, b.Min, draw.Src)
+	small := resizeBox(rgba, w, h)
+	out := make([]uint8, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			p := small.Pix[y*small.Stride+x*4:]
+			out[y*w+x] = uint8((299*int(p[0]) + 587*int(p[1]) + 114*int(p[2])) / 1000)
+		}
+	}
+	return out
+}
@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+// squarePNG draws a white square at (x, y) on a black 64x48 frame.
+func squarePNG(t *testing.T, x, y int) *bytes.Buffer {
+	t.Helper()
+	img := image.NewGray(image.Rect(0, 0, 64, 48))
+	for sy := y; sy < y+16; sy++ {
+		for sx := x; sx < x+16; sx++ {
+			img.SetGray(sx, sy, color.Gray{Y: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func TestMotionScoreAndThreshold(t *testing.T) {
+	store := newTestStore(t, t.TempDir())
+	store.Use(NewMotionDetector(0.05))
+
+	first, err := store.SaveFrame(FrameInput{FrameID: "a"}, squarePNG(t, 0, 0))
+	if err != nil || first.MotionScore != nil || first.Motion {
+		t.Fatalf("the first frame has nothing to compare with: %+v err=%v", first, err)
+	}
+	still, err := store.SaveFrame(FrameInput{FrameID: "b"}, squarePNG(t, 0, 0))
+	if err != nil || still.MotionScore == nil || *still.MotionScore != 0 || still.Motion {
+		t.Fatalf("expected no motion for an identical frame: %+v err=%v", still, err)
+	}
+	// moving the square changes 2 * 256 of 3072 pixels by 255
+	moved, err := store.SaveFrame(FrameInput{FrameID: "c"}, squarePNG(t, 32, 16))
+	if err != nil || moved.MotionScore == nil || !moved.Motion {
+		t.Fatalf("expected motion for a moved square: %+v err=%v", moved, err)
+	}
+	if got := *moved.MotionScore; got < 0.15 || got > 0.18 {
+		t.Fatalf("unexpected motion score %v", got)
+	}
+}
+
+func TestMotionEventPublishedAfterCommit(t *testing.T) {
+	store := newTestStore(t, t.TempDir())
+	failing := &failingProcessor{}
+	store.Use(NewMotionDetector(0.05))
+	store.Use(failing)
+	var events []Event
+	cancel := store.Subscribe(func(ev Event) {
+		if _, ok := store.Frame(ev.Frame.FileName); !ok {
+			t.Errorf("event published before %s was indexed", ev.Frame.FileName)
+		}
+		if ev.Type != EventMotion {
+			return
+		}
+		events = append(events, ev)
+	})
+
+	if _, err := store.SaveFrame(FrameInput{FrameID: "a"}, squarePNG(t, 0, 0)); err != nil {
+		t.Fatal(err)
+	}
+	failing.fail = true
+	if _, err := store.SaveFrame(FrameInput{FrameID: "b"}, squarePNG(t, 32, 16)); err == nil {
+		t.Fatal("expected the failing stage to abort the upload")
+	}
+	if len(events) != 0 {
+		t.Fatalf("expected no event for a frame that was not stored, got %+v", events)
+	}
+	failing.fail = false
+	moved, err := store.SaveFrame(FrameInput{FrameID: "c"}, squarePNG(t, 32, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Type != EventMotion || events[0].Frame.FileName != moved.FileName || !events[0].Frame.Motion {
+		t.Fatalf("unexpected events: %+v", events)
+	}
+
+	cancel()
+	if _, err := store.SaveFrame(FrameInput{FrameID: "d"}, squarePNG(t, 0, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("expected no events after unsubscribing, got %d", len(events))
+	}
+}
@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"math/bits"
+	"strconv"
+)
+
+// PHasher computes a 64-bit difference hash (dHash) of every decodable
+// frame and compares it with the last frame in the index. Frames within
+// Threshold bits of it are flagged as unchanged or, with Skip, dropped
+// before they reach the backend. The reference is read from the index on
+// every upload, so frames that fail to commit never become it.
+type PHasher struct {
+	Threshold int
+	Skip      bool
+}
+
+func NewPHasher(threshold int, skip bool) *PHasher {
+	return &PHasher{Threshold: threshold, Skip: skip}
+}
+
+func (p *PHasher) Process(f *PendingFrame) error {
+	img, err := f.Image()
+	if err != nil {
+		// frames that cannot be decoded are always stored
+		return nil
+	}
+	hash := dHash(img)
+	f.Meta.PHash = fmt.Sprintf("%016x", hash)
+
+	last, n := f.store.LastMeta()
+	if n == 0 {
+		return nil
+	}
+	ref, ok := parsePHash(last.PHash)
+	if !ok || bits.OnesCount64(hash^ref) >= p.Threshold {
+		return nil
+	}
+	f.Meta.Unchanged = true
+	// a skipped frame is never indexed, so slow drift still produces a new
+	// frame once it exceeds the threshold
+	f.skip = p.Skip
+	return nil
+}
+
+func parsePHash(s string) (uint64, bool) {
+	v, err := strconv.ParseUint(s, 16, 64)
+	return v, err == nil
+}
+
+// dHash reduces img to a 9x8 grayscale grid and sets one bit per pair of
+// horizontally adjacent cells whose left cell is brighter.
+func dHash(img image.Image) uint64 {
+	b := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, b.Min, draw.Src)
+	small := resizeBox(rgba, 9, 8)
+	var gray [8][9]int
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 9; x++ {
+			p := small.Pix[y*small.Stride+x*4:]
+			gray[y][x] = 299*int(p[0]) + 587*int(p[1]) + 114*int(p[2])
+		}
+	}
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if gray[y][x] > gray[y][x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+func gradientPNG(t *testing.T, shift uint8, bar int) *bytes.Buffer {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 90, 80))
+	for y := 0; y < 80; y++ {
+		for x := 0; x < 90; x++ {
+			v := uint8(x*2) + shift
+			if x >= bar && x < bar+10 {
+				v = 255
+			}
+			img.Set(x, y, color.RGBA{R: v, G: v, B: v, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func TestPHasherSkipsUnchangedFrames(t *testing.T) {
+	store := newTestStore(t, t.TempDir())
+	store.Use(NewPHasher(5, true))
+
+	first, err := store.SaveFrame(FrameInput{FrameID: "a"}, gradientPNG(t, 0, 200))
+	if err != nil || first.Skipped || first.Unchanged || first.PHash == "" {
+		t.Fatalf("unexpected first frame: %+v err=%v", first, err)
+	}
+	// a brightness shift changes every byte but not the gradient
+	second, err := store.SaveFrame(FrameInput{FrameID: "b"}, gradientPNG(t, 3, 200))
+	if err != nil || !second.Skipped || !second.Unchanged || second.FileName != "" {
+		t.Fatalf("expected near-duplicate to be skipped: %+v err=%v", second, err)
+	}
+	third, err := store.SaveFrame(FrameInput{FrameID: "c"}, gradientPNG(t, 0, 40))
+	if err != nil || third.Skipped || third.Unchanged {
+		t.Fatalf("expected changed frame to be stored: %+v err=%v", third, err)
+	}
+	if _, n := store.LastMeta(); n != 2 {
+		t.Fatalf("expected 2 stored frames, got %d", n)
+	}
+}
+
+func TestPHasherFlagsUnchangedFrames(t *testing.T) {
+	dir := t.TempDir()
+	store := newTestStore(t, dir)
+	if _, err := store.SaveFrame(FrameInput{FrameID: "a"}, gradientPNG(t, 0, 200)); err != nil {
+		t.Fatal(err)
+	}
+	store.Use(NewPHasher(5, false))
+	// the reference is seeded from the index only when the frame has a hash
+	first, err := store.SaveFrame(FrameInput{FrameID: "b"}, gradientPNG(t, 0, 200))
+	if err != nil || first.Unchanged {
+		t.Fatalf("unexpected first hashed frame: %+v err=%v", first, err)
+	}
+	store.Close()
+
+	reopened := newTestStore(t, dir)
+	reopened.Use(NewPHasher(5, false))
+	meta, err := reopened.SaveFrame(FrameInput{FrameID: "c"}, gradientPNG(t, 3, 200))
+	if err != nil || !meta.Unchanged || meta.Skipped || meta.FileName == "" {
+		t.Fatalf("expected stored frame flagged unchanged: %+v err=%v", meta, err)
+	}
+}
+
+type failingProcessor struct{ fail bool }
+
+func (p *failingProcessor) Process(*PendingFrame) error {
+	if p.fail {
+		return errors.New("processing failed")
+	}
+	return nil
+}
+
+func TestPHasherIgnoresFramesThatFailToCommit(t *testing.T) {
+	store := newTestStore(t, t.TempDir())
+	failing := &failingProcessor{}
+	store.Use(NewPHasher(5, true))
+	store.Use(failing)
+
+	if _, err := store.SaveFrame(FrameInput{FrameID: "a"}, gradientPNG(t, 0, 200)); err != nil {
+		t.Fatal(err)
+	}
+	failing.fail = true
+	if _, err := store.SaveFrame(FrameInput{FrameID: "b"}, gradientPNG(t, 0, 40)); err == nil {
+		t.Fatal("expected the failing stage to abort the upload")
+	}
+	failing.fail = false
+	// compared with the stored frame, not with the one that failed
+	meta, err := store.SaveFrame(FrameInput{FrameID: "c"}, gradientPNG(t, 0, 40))
+	if err != nil || meta.Skipped || meta.Unchanged {
+		t.Fatalf("expected frame to be stored: %+v err=%v", meta, err)
+	}
+}
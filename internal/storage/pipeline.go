@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+)
+
+// Processor is a stage of the save pipeline. Stages run in registration
+// order on the spooled payload, after validation and before the frame is
+// committed, and may annotate its metadata or attach derived files.
+type Processor interface {
+	Process(f *PendingFrame) error
+}
+
+type PendingFrame struct {
+	Meta      FrameMeta
+	SpoolPath string
+
+	store       *FrameStore
+	img         image.Image
+	imgErr      error
+	decoded     bool
+	skip        bool
+	events      []string
+	onCommit    []func(FrameMeta)
+	attachments []attachment
+}
+
+type attachment struct {
+	fileName    string
+	contentType string
+	tmpPath     string
+}
+
+// Use registers a pipeline stage. It must be called before the store starts
+// serving uploads.
+func (s *FrameStore) Use(p Processor) {
+	s.processors = append(s.processors, p)
+}
+
+// Image decodes the spooled payload once and shares the result between
+// stages. Formats without a standard library decoder report
+// ErrUnsupportedMediaType.
+func (f *PendingFrame) Image() (image.Image, error) {
+	if f.decoded {
+		return f.img, f.imgErr
+	}
+	f.decoded = true
+	if f.imgErr = f.store.checkPixels(f.Meta.Width, f.Meta.Height); f.imgErr != nil {
+		return nil, f.imgErr
+	}
+	file, err := os.Open(f.SpoolPath)
+	if err != nil {
+		f.imgErr = fmt.Errorf("open spool file: %w", err)
+		return nil, f.imgErr
+	}
+	defer file.Close()
+	f.img, f.imgErr = decodeImage(file, f.Meta.Format)
+	return f.img, f.imgErr
+}
+
+// Attach writes a derived file that is committed next to the frame as
+// <file_name><suffix>, returning the final file name.
+func (f *PendingFrame) Attach(suffix, contentType string, write func(w io.Writer) error) (string, error) {
+	tmp, err := os.CreateTemp(f.store.framesDir, spoolPrefix+"*")
+	if err != nil {
+		return "", fmt.Errorf("create attachment: %w", err)
+	}
+	err = write(tmp)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return "", fmt.Errorf("write attachment: %w", err)
+	}
+	name := f.Meta.FileName + suffix
+	f.attachments = append(f.attachments, attachment{fileName: name, contentType: contentType, tmpPath: tmp.Name()})
+	return name, nil
+}
+
+// Emit queues an event that is published with the final metadata once the
+// frame has been committed. Frames that are skipped or fail to commit
+// publish nothing.
+func (f *PendingFrame) Emit(eventType string) {
+	f.events = append(f.events, eventType)
+}
+
+// OnCommit registers fn to run with the final metadata once the frame has
+// been committed, for stages that keep state about the last stored frame.
+func (f *PendingFrame) OnCommit(fn func(FrameMeta)) {
+	f.onCommit = append(f.onCommit, fn)
+}
+
+func (f *PendingFrame) cleanup() {
+	for _, a := range f.attachments {
+		_ = os.Remove(a.tmpPath)
+	}
+}
+
+func decodeImage(r io.Reader, format string) (image.Image, error) {
+	switch format {
+	case "jpeg":
+		return jpeg.Decode(r)
+	case "png":
+		return png.Decode(r)
+	case "gif":
+		return gif.Decode(r)
+	default:
+		return nil, fmt.Errorf("%w: cannot decode %s", ErrUnsupportedMediaType, format)
+	}
+}
@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+type RetentionPolicy struct {
+	MaxAge   time.Duration
+	MaxBytes int64
+	MaxFiles int
+	Interval time.Duration
+}
+
+func (p RetentionPolicy) Enabled() bool {
+	return p.MaxAge > 0 || p.MaxBytes > 0 || p.MaxFiles > 0
+}
+
+type RetentionResult struct {
+	DeletedFiles int
+	DeletedBytes int64
+}
+
+func (s *FrameStore) StartRetention(p RetentionPolicy) {
+	if !p.Enabled() {
+		return
+	}
+	if p.Interval <= 0 {
+		p.Interval = 5 * time.Minute
+	}
+	go s.retentionLoop(p)
+}
+
+func (s *FrameStore) retentionLoop(p RetentionPolicy) {
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		_, _ = s.ApplyRetention(p, time.Now().UTC())
+	}
+}
+
+func (s *FrameStore) ApplyRetention(p RetentionPolicy, now time.Time) (RetentionResult, error) {
+	victims, err := s.selectRetentionVictims(p, now)
+	if err != nil || len(victims) == 0 {
+		return RetentionResult{}, err
+	}
+
+	// blobs are deleted outside the lock, as a remote backend may be slow
+	var res RetentionResult
+	var errs []error
+	for _, m := range victims {
+		if err := s.removeFrameBlobs(m); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		res.DeletedFiles++
+		res.DeletedBytes += m.Size
+	}
+	if s.metrics != nil {
+		s.metrics.RetentionDeletedFiles.Add(float64(res.DeletedFiles))
+		s.metrics.RetentionDeletedBytes.Add(float64(res.DeletedBytes))
+	}
+	return res, errors.Join(errs...)
+}
+
+func (s *FrameStore) selectRetentionVictims(p RetentionPolicy, now time.Time) ([]FrameMeta, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var victims []FrameMeta
+	files, size := len(s.frames), s.totalBytes
+	for _, m := range s.frames {
+		expired := p.MaxAge > 0 && now.Sub(m.ReceivedAt) > p.MaxAge
+		overFiles := p.MaxFiles > 0 && files > p.MaxFiles
+		overBytes := p.MaxBytes > 0 && size > p.MaxBytes
+		if !expired && !overFiles && !overBytes {
+			break
+		}
+		victims = append(victims, *m)
+		files--
+		size -= m.Size
+	}
+	if len(victims) == 0 {
+		return nil, nil
+	}
+	if err := s.forgetFramesLocked(victims); err != nil {
+		return nil, err
+	}
+	s.updateMetricsLocked()
+	return victims, nil
+}
+
+// forgetFramesLocked drops frames from the index and the idempotency map and
+// journals the removal before any file is deleted, so a crash can at worst
+// leave an orphaned file behind, never a dangling duplicate.
+func (s *FrameStore) forgetFramesLocked(frames []FrameMeta) error {
+	names := make(map[string]struct{}, len(frames))
+	for _, m := range frames {
+		names[m.FileName] = struct{}{}
+		if err := s.indexJournal.append(indexRecord{Op: "del", FileName: m.FileName}); err != nil {
+			return fmt.Errorf("journal frame removal: %w", err)
+		}
+		s.removeIndexLocked(m.FileName)
+	}
+	for key, entry := range s.byIdempotency {
+		if _, ok := names[entry.frameMeta.FileName]; !ok {
+			continue
+		}
+		if err := s.idemJournal.append(idemRecord{Key: key, Deleted: true}); err != nil {
+			return fmt.Errorf("journal idempotency removal: %w", err)
+		}
+		s.removeIdemEntryLocked(key)
+	}
+	if s.indexJournal.shouldCompact(len(s.frames)) {
+		_ = s.indexJournal.rewrite(s.indexRecordsLocked())
+	}
+	return nil
+}
+
+func (s *FrameStore) removeFrameBlobs(m FrameMeta) error {
+	if m.ThumbnailFileName != "" {
+		if err := s.backend.Remove(m.ThumbnailFileName); err != nil {
+			return err
+		}
+	}
+	return s.backend.Remove(m.FileName)
+}
@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"ermete/internal/observability"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestRetentionMaxFilesAndBytes(t *testing.T) {
+	store, err := NewFrameStore(t.TempDir(), 10*time.Minute, 100, observability.NewMetrics(prometheus.NewRegistry()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	var saved []FrameMeta
+	for i := 0; i < 5; i++ {
+		m, err := store.SaveFrame(FrameInput{FrameID: "f", ContentType: "image/png"}, bytes.NewReader(testImage(t, "png", "0123456789")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		saved = append(saved, m)
+	}
+
+	res, err := store.ApplyRetention(RetentionPolicy{MaxFiles: 3}, time.Now().UTC())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.DeletedFiles != 2 || res.DeletedBytes != saved[0].Size+saved[1].Size {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+	if _, err := os.Stat(saved[0].Path); !os.IsNotExist(err) {
+		t.Fatalf("expected oldest frame file to be deleted, err=%v", err)
+	}
+	if _, ok := store.Frame(saved[0].FileName); ok {
+		t.Fatal("expected oldest frame to be removed from index")
+	}
+
+	res, err = store.ApplyRetention(RetentionPolicy{MaxBytes: saved[4].Size}, time.Now().UTC())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.DeletedFiles != 2 {
+		t.Fatalf("expected 2 deletions for byte limit, got %+v", res)
+	}
+	if last, count := store.LastMeta(); count != 1 || last.FileName != saved[4].FileName {
+		t.Fatalf("expected newest frame to survive, got %+v count=%d", last, count)
+	}
+}
+
+func TestRetentionForgetsIdempotencyAcrossRestart(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFrameStore(dir, 10*time.Minute, 100, observability.NewMetrics(prometheus.NewRegistry()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, err := store.SaveFrame(FrameInput{FrameID: "f", IdempotencyKey: "idem-key", ContentType: "image/png"}, bytes.NewReader(testImage(t, "png", "x")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.ApplyRetention(RetentionPolicy{MaxAge: time.Minute}, time.Now().UTC().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	_ = store.Close()
+
+	reopened, err := NewFrameStore(dir, 10*time.Minute, 100, observability.NewMetrics(prometheus.NewRegistry()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if _, count := reopened.LastMeta(); count != 0 {
+		t.Fatalf("expected empty index after restart, got %d frames", count)
+	}
+	again, err := reopened.SaveFrame(FrameInput{FrameID: "f", IdempotencyKey: "idem-key", ContentType: "image/png"}, bytes.NewReader(testImage(t, "png", "x")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Duplicate || again.FileName == old.FileName {
+		t.Fatalf("deleted frame must not be returned as duplicate: %+v", again)
+	}
+}
+
+func TestRetentionRemovesPreExistingFrames(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now().UTC()
+	names := writeLegacyFrames(t, dir, now.Add(-72*time.Hour), now.Add(-48*time.Hour), now.Add(-2*time.Hour), now.Add(-time.Hour))
+	store := newTestStore(t, dir)
+
+	res, err := store.ApplyRetention(RetentionPolicy{MaxAge: 24 * time.Hour}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.DeletedFiles != 2 {
+		t.Fatalf("expected the two frames older than a day to be deleted, got %+v", res)
+	}
+	for _, name := range names[:2] {
+		if _, err := os.Stat(filepath.Join(dir, "frames", name)); !os.IsNotExist(err) {
+			t.Fatalf("expected %s to be deleted, err=%v", name, err)
+		}
+	}
+
+	if res, err = store.ApplyRetention(RetentionPolicy{MaxFiles: 1}, now); err != nil || res.DeletedFiles != 1 {
+		t.Fatalf("expected one deletion for the file limit, got %+v err=%v", res, err)
+	}
+	if last, count := store.LastMeta(); count != 1 || last.FileName != names[3] {
+		t.Fatalf("expected newest pre-existing frame to survive, got %+v count=%d", last, count)
+	}
+}
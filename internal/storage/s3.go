@@ -0,0 +1,375 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+type S3Options struct {
+	Endpoint        string
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+	Prefix          string
+	PathStyle       bool
+	Client          *http.Client
+}
+
+// S3Backend stores frames in an S3-compatible bucket (AWS S3, MinIO, Ceph
+// RGW, ...) using plain REST calls signed with AWS Signature Version 4.
+type S3Backend struct {
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	accessKey string
+	secretKey string
+	prefix    string
+	pathStyle bool
+	client    *http.Client
+	now       func() time.Time
+}
+
+func NewS3Backend(opts S3Options) (*S3Backend, error) {
+	if opts.Bucket == "" {
+		return nil, errors.New("s3 bucket is required")
+	}
+	u, err := url.Parse(opts.Endpoint)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid s3 endpoint %q", opts.Endpoint)
+	}
+	if opts.Region == "" {
+		opts.Region = "us-east-1"
+	}
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: 60 * time.Second}
+	}
+	return &S3Backend{
+		endpoint:  u,
+		region:    opts.Region,
+		bucket:    opts.Bucket,
+		accessKey: opts.AccessKeyID,
+		secretKey: opts.SecretAccessKey,
+		prefix:    strings.Trim(opts.Prefix, "/"),
+		pathStyle: opts.PathStyle,
+		client:    opts.Client,
+		now:       time.Now,
+	}, nil
+}
+
+func (b *S3Backend) objectKey(key string) string {
+	if b.prefix == "" {
+		return key
+	}
+	return b.prefix + "/" + key
+}
+
+func (b *S3Backend) objectURL(key string) *url.URL {
+	u := *b.endpoint
+	base := strings.TrimSuffix(u.Path, "/")
+	if b.pathStyle {
+		u.Path = base + "/" + b.bucket + "/" + b.objectKey(key)
+	} else {
+		u.Host = b.bucket + "." + u.Host
+		u.Path = base + "/" + b.objectKey(key)
+	}
+	u.RawPath = s3EscapePath(u.Path)
+	return &u
+}
+
+func (b *S3Backend) do(method, key string, body io.Reader, size int64, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(method, b.objectURL(key).String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	b.sign(req, b.now())
+	return b.client.Do(req)
+}
+
+func (b *S3Backend) Commit(key, srcPath, contentType string) error {
+	if !validKey(key) {
+		return fmt.Errorf("invalid key %q", key)
+	}
+	f, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	header := http.Header{}
+	header.Set("Content-Type", contentType)
+	header.Set("X-Amz-Content-Sha256", hex.EncodeToString(h.Sum(nil)))
+	resp, err := b.do(http.MethodPut, key, f, size, header)
+	if err != nil {
+		return fmt.Errorf("s3 put %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return s3Error(http.MethodPut, key, resp)
+	}
+	_ = os.Remove(srcPath)
+	return nil
+}
+
+func (b *S3Backend) Open(key string) (io.ReadSeekCloser, error) {
+	if !validKey(key) {
+		return nil, ErrFrameNotFound
+	}
+	resp, err := b.do(http.MethodHead, key, nil, 0, nil)
+	if err != nil {
+		return nil, fmt.Errorf("s3 head %s: %w", key, err)
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, ErrFrameNotFound
+	case resp.StatusCode/100 != 2:
+		return nil, s3Error(http.MethodHead, key, resp)
+	}
+	size, err := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("s3 head %s: missing content length", key)
+	}
+	return &s3Object{backend: b, key: key, size: size}, nil
+}
+
+func (b *S3Backend) Remove(key string) error {
+	if !validKey(key) {
+		return ErrFrameNotFound
+	}
+	resp, err := b.do(http.MethodDelete, key, nil, 0, nil)
+	if err != nil {
+		return fmt.Errorf("s3 delete %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 && resp.StatusCode != http.StatusNotFound {
+		return s3Error(http.MethodDelete, key, resp)
+	}
+	return nil
+}
+
+// Rename copies the object server side and deletes the source, as S3 has
+// no rename operation.
+func (b *S3Backend) Rename(oldKey, newKey string) error {
+	if !validKey(oldKey) || !validKey(newKey) {
+		return ErrFrameNotFound
+	}
+	header := http.Header{}
+	header.Set("X-Amz-Copy-Source", s3EscapePath("/"+b.bucket+"/"+b.objectKey(oldKey)))
+	resp, err := b.do(http.MethodPut, newKey, nil, 0, header)
+	if err != nil {
+		return fmt.Errorf("s3 copy %s: %w", oldKey, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	// a copy can fail after the 200 status line has been sent, in which
+	// case the error is only reported in the body
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode/100 != 2 || strings.Contains(string(body), "<Error>") {
+		return fmt.Errorf("s3 copy %s: %s: %s", oldKey, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return b.Remove(oldKey)
+}
+
+func (b *S3Backend) Location(key string) string {
+	return "s3://" + b.bucket + "/" + b.objectKey(key)
+}
+
+func s3Error(method, key string, resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return fmt.Errorf("s3 %s %s: %s: %s", strings.ToLower(method), key, resp.Status, strings.TrimSpace(string(body)))
+}
+
+// s3Object reads an object lazily with ranged GETs, so seeking (as done by
+// http.ServeContent for Range requests) never downloads skipped bytes.
+type s3Object struct {
+	backend *S3Backend
+	key     string
+	size    int64
+	off     int64
+	body    io.ReadCloser
+}
+
+func (o *s3Object) Read(p []byte) (int, error) {
+	if o.off >= o.size {
+		return 0, io.EOF
+	}
+	if o.body == nil {
+		header := http.Header{}
+		header.Set("Range", "bytes="+strconv.FormatInt(o.off, 10)+"-")
+		resp, err := o.backend.do(http.MethodGet, o.key, nil, 0, header)
+		if err != nil {
+			return 0, fmt.Errorf("s3 get %s: %w", o.key, err)
+		}
+		if resp.StatusCode != http.StatusPartialContent && !(resp.StatusCode == http.StatusOK && o.off == 0) {
+			defer resp.Body.Close()
+			return 0, s3Error(http.MethodGet, o.key, resp)
+		}
+		o.body = resp.Body
+	}
+	n, err := o.body.Read(p)
+	o.off += int64(n)
+	return n, err
+}
+
+func (o *s3Object) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = o.off + offset
+	case io.SeekEnd:
+		abs = o.size + offset
+	default:
+		return 0, errors.New("s3: invalid whence")
+	}
+	if abs < 0 {
+		return 0, errors.New("s3: negative position")
+	}
+	if abs != o.off && o.body != nil {
+		o.body.Close()
+		o.body = nil
+	}
+	o.off = abs
+	return abs, nil
+}
+
+func (o *s3Object) Close() error {
+	if o.body == nil {
+		return nil
+	}
+	err := o.body.Close()
+	o.body = nil
+	return err
+}
+
+// sign adds an AWS Signature Version 4 Authorization header. The payload
+// hash is taken from X-Amz-Content-Sha256, defaulting to UNSIGNED-PAYLOAD.
+func (b *S3Backend) sign(req *http.Request, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	payloadHash := req.Header.Get("X-Amz-Content-Sha256")
+	if payloadHash == "" {
+		payloadHash = unsignedPayload
+		req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		name := strings.ToLower(k)
+		if name == "range" || name == "content-type" || name == "content-md5" || strings.HasPrefix(name, "x-amz-") {
+			headers[name] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s3EscapePath(req.URL.Path),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + b.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hexSHA256([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+b.secretKey), date)
+	key = hmacSHA256(key, b.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+b.accessKey+"/"+scope+", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func canonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		vals := append([]string(nil), q[k]...)
+		sort.Strings(vals)
+		for _, v := range vals {
+			parts = append(parts, s3Escape(k)+"="+s3Escape(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func s3EscapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, s := range segments {
+		segments[i] = s3Escape(s)
+	}
+	return path.Clean("/" + strings.Join(segments, "/"))
+}
+
+// s3Escape percent-encodes everything but the RFC 3986 unreserved
+// characters, as SigV4 requires.
+func s3Escape(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '.' || c == '_' || c == '~' {
+			sb.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&sb, "%%%02X", c)
+	}
+	return sb.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(data))
+	return m.Sum(nil)
+}
+
+func hexSHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
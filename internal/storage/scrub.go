@@ -0,0 +1,271 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// quarantineDir is the backend prefix bad files are moved under. Walks of
+// the frames directory skip it like every dot entry.
+const quarantineDir = ".quarantine"
+
+const (
+	// orphanGrace leaves alone files modified shortly before a scrub: a
+	// spool file keeps the mtime of its last write when commit renames it
+	// into place, and filesystem clocks are coarse.
+	orphanGrace = time.Minute
+	// orphanRecheckDelay is how long a live scrub waits before checking
+	// orphan candidates against the index again, so that frames renamed
+	// into place but not yet indexed by a commit in flight get indexed.
+	orphanRecheckDelay = 5 * time.Second
+)
+
+const (
+	ScrubMissing    = "missing"
+	ScrubCorrupted  = "corrupted"
+	ScrubOrphaned   = "orphaned"
+	ScrubUnverified = "unverified"
+)
+
+type ScrubIssue struct {
+	FileName    string `json:"file_name"`
+	Problem     string `json:"problem"`
+	Detail      string `json:"detail,omitempty"`
+	Quarantined bool   `json:"quarantined,omitempty"`
+}
+
+type ScrubReport struct {
+	Checked     int          `json:"checked"`
+	Missing     int          `json:"missing"`
+	Corrupted   int          `json:"corrupted"`
+	Orphaned    int          `json:"orphaned"`
+	Unverified  int          `json:"unverified"`
+	Quarantined int          `json:"quarantined"`
+	Issues      []ScrubIssue `json:"issues,omitempty"`
+}
+
+func (r *ScrubReport) add(issue ScrubIssue) {
+	switch issue.Problem {
+	case ScrubMissing:
+		r.Missing++
+	case ScrubCorrupted:
+		r.Corrupted++
+	case ScrubOrphaned:
+		r.Orphaned++
+	case ScrubUnverified:
+		r.Unverified++
+	}
+	if issue.Quarantined {
+		r.Quarantined++
+	}
+	r.Issues = append(r.Issues, issue)
+}
+
+// StartScrub verifies the store every interval in the background.
+func (s *FrameStore) StartScrub(interval time.Duration, quarantine bool) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			_, _ = s.Scrub(quarantine)
+		}
+	}()
+}
+
+// Scrub re-hashes every indexed frame against its recorded SHA-256 and,
+// on the local backend, looks for files under the frames directory that no
+// indexed frame refers to. Frames whose key is not in the keyring are
+// reported as unverified. With quarantine, corrupted frames are moved under
+// .quarantine and dropped from the index, and orphaned files are moved
+// there too; missing frames are only reported.
+func (s *FrameStore) Scrub(quarantine bool) (ScrubReport, error) {
+	var rep ScrubReport
+	if quarantine && s.readOnly {
+		return rep, ErrReadOnly
+	}
+	// a read-only store does not see frames committed after it was opened
+	started := time.Now()
+	if s.readOnly {
+		started = s.openedAt
+	}
+	s.mu.Lock()
+	frames := make([]FrameMeta, 0, len(s.frames))
+	for _, m := range s.frames {
+		frames = append(frames, *m)
+	}
+	s.mu.Unlock()
+
+	var bad []FrameMeta
+	for _, m := range frames {
+		rep.Checked++
+		issue, ok := s.verifyFrame(m)
+		if !ok {
+			continue
+		}
+		if quarantine && issue.Problem == ScrubCorrupted {
+			if err := s.quarantineBlobs(m.FileName, m.ThumbnailFileName); err != nil {
+				issue.Detail += "; quarantine failed: " + err.Error()
+			} else {
+				issue.Quarantined = true
+				bad = append(bad, m)
+			}
+		}
+		rep.add(issue)
+	}
+	if len(bad) > 0 {
+		s.mu.Lock()
+		err := s.forgetFramesLocked(bad)
+		s.updateMetricsLocked()
+		s.mu.Unlock()
+		if err != nil {
+			return rep, err
+		}
+	}
+
+	orphans, err := s.findOrphans(started)
+	if err != nil {
+		return rep, err
+	}
+	for _, key := range orphans {
+		issue := ScrubIssue{FileName: key, Problem: ScrubOrphaned}
+		if quarantine {
+			if err := s.quarantineBlobs(key); err != nil {
+				issue.Detail = "quarantine failed: " + err.Error()
+			} else {
+				issue.Quarantined = true
+			}
+		}
+		rep.add(issue)
+	}
+	s.recordScrub(rep)
+	return rep, nil
+}
+
+func (s *FrameStore) verifyFrame(m FrameMeta) (ScrubIssue, bool) {
+	issue := ScrubIssue{FileName: m.FileName}
+	f, err := s.openBlob(m.FileName)
+	if err == nil {
+		h := sha256.New()
+		_, err = io.Copy(h, f)
+		_ = f.Close()
+		if err == nil {
+			if sum := hex.EncodeToString(h.Sum(nil)); sum != m.SHA256 {
+				issue.Problem, issue.Detail = ScrubCorrupted, fmt.Sprintf("sha256 %s, recorded %s", sum, m.SHA256)
+				return issue, true
+			}
+			return issue, false
+		}
+	}
+	switch {
+	case errors.Is(err, ErrFrameNotFound):
+		issue.Problem = ScrubMissing
+	case errors.Is(err, ErrKeyUnavailable):
+		issue.Problem, issue.Detail = ScrubUnverified, err.Error()
+	default:
+		issue.Problem, issue.Detail = ScrubCorrupted, err.Error()
+	}
+	return issue, true
+}
+
+// findOrphans lists local files that no indexed frame or thumbnail refers
+// to. Files modified later than orphanGrace before since may belong to an
+// upload that is being committed and are left alone. Candidates are checked
+// against the index once the walk is done and, on a store the server may
+// be committing to, a second time after orphanRecheck.
+func (s *FrameStore) findOrphans(since time.Time) ([]string, error) {
+	if _, ok := s.backend.(*LocalBackend); !ok {
+		return nil, nil
+	}
+	cutoff := since.Add(-orphanGrace)
+	var candidates []string
+	err := filepath.WalkDir(s.framesDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(d.Name(), ".") && p != s.framesDir {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			return nil
+		}
+		rel, err := filepath.Rel(s.framesDir, p)
+		if err != nil {
+			return err
+		}
+		candidates = append(candidates, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("walk frames dir: %w", err)
+	}
+	orphans := s.unindexed(candidates)
+	// a read-only store never sees new commits, and maintenance tools run
+	// with the server stopped
+	if len(orphans) > 0 && !s.readOnly && !s.maintenance && s.orphanRecheck > 0 {
+		time.Sleep(s.orphanRecheck)
+		orphans = s.unindexed(orphans)
+	}
+	return orphans, nil
+}
+
+// unindexed returns the keys that no indexed frame or thumbnail uses.
+func (s *FrameStore) unindexed(keys []string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	known := make(map[string]struct{}, 2*len(s.frames))
+	for _, m := range s.frames {
+		known[m.FileName] = struct{}{}
+		if m.ThumbnailFileName != "" {
+			known[m.ThumbnailFileName] = struct{}{}
+		}
+	}
+	var out []string
+	for _, key := range keys {
+		if _, ok := known[key]; !ok {
+			out = append(out, key)
+		}
+	}
+	return out
+}
+
+func (s *FrameStore) quarantineBlobs(keys ...string) error {
+	for _, key := range keys {
+		if key == "" {
+			continue
+		}
+		if err := s.backend.Rename(key, quarantineDir+"/"+key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *FrameStore) recordScrub(rep ScrubReport) {
+	if s.metrics == nil {
+		return
+	}
+	s.metrics.ScrubCheckedFrames.Set(float64(rep.Checked))
+	s.metrics.ScrubMissingFrames.Set(float64(rep.Missing))
+	s.metrics.ScrubCorruptedFrames.Set(float64(rep.Corrupted))
+	s.metrics.ScrubOrphanedFiles.Set(float64(rep.Orphaned))
+	s.metrics.ScrubUnverifiedFrames.Set(float64(rep.Unverified))
+	s.metrics.ScrubQuarantinedTotal.Add(float64(rep.Quarantined))
+	s.metrics.ScrubLastRunTimestamp.Set(float64(time.Now().Unix()))
+}
@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"ermete/internal/observability"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func gaugeValue(t *testing.T, reg *prometheus.Registry, name string) float64 {
+	t.Helper()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range families {
+		if f.GetName() == name {
+			return f.GetMetric()[0].GetGauge().GetValue()
+		}
+	}
+	t.Fatalf("metric %s not registered", name)
+	return 0
+}
+
+func TestScrubFindsAndQuarantinesBadFiles(t *testing.T) {
+	dir := t.TempDir()
+	reg := prometheus.NewRegistry()
+	store, err := OpenFrameStore(Options{DataDir: dir, Metrics: observability.NewMetrics(reg)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	store.orphanRecheck = time.Millisecond
+	store.Use(NewThumbnailer(8, 80))
+	var metas []FrameMeta
+	for _, seed := range []string{"good", "bitrot", "gone"} {
+		m, err := store.SaveFrame(FrameInput{FrameID: seed}, bytes.NewReader(testImage(t, "png", seed)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		metas = append(metas, m)
+	}
+	framePath := func(name string) string { return filepath.Join(dir, "frames", filepath.FromSlash(name)) }
+	if err := os.WriteFile(framePath(metas[1].FileName), testImage(t, "png", "bitrox"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(framePath(metas[2].FileName)); err != nil {
+		t.Fatal(err)
+	}
+	orphan := framePath("stray.png")
+	if err := os.WriteFile(orphan, testImage(t, "png", "stray"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(orphan, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	rep, err := store.Scrub(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Checked != 3 || rep.Missing != 1 || rep.Corrupted != 1 || rep.Orphaned != 1 || rep.Quarantined != 0 {
+		t.Fatalf("unexpected report: %+v", rep)
+	}
+	if got := gaugeValue(t, reg, "ermete_scrub_corrupted_frames"); got != 1 {
+		t.Fatalf("expected corrupted gauge 1, got %v", got)
+	}
+
+	rep, err = store.Scrub(true)
+	if err != nil || rep.Quarantined != 2 {
+		t.Fatalf("expected 2 quarantined files: %+v err=%v", rep, err)
+	}
+	if _, ok := store.Frame(metas[1].FileName); ok {
+		t.Fatal("quarantined frame is still indexed")
+	}
+	for _, name := range []string{metas[1].FileName, metas[1].ThumbnailFileName, "stray.png"} {
+		if _, err := os.Stat(framePath(quarantineDir + "/" + name)); err != nil {
+			t.Fatalf("%s not quarantined: %v", name, err)
+		}
+	}
+
+	rep, err = store.Scrub(false)
+	if err != nil || rep.Checked != 2 || rep.Missing != 1 || len(rep.Issues) != 1 {
+		t.Fatalf("expected only the missing frame to remain: %+v err=%v", rep, err)
+	}
+	if got := counterValue(t, reg, "ermete_scrub_quarantined_total"); got != 2 {
+		t.Fatalf("expected 2 quarantined in total, got %v", got)
+	}
+}
+
+func TestScrubReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	store := newTestStore(t, dir)
+	if _, err := store.SaveFrame(FrameInput{FrameID: "f"}, bytes.NewReader(testImage(t, "png", "f"))); err != nil {
+		t.Fatal(err)
+	}
+	ro, err := OpenFrameStore(Options{DataDir: dir, ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ro.Close()
+	// frames committed by the server after the read-only open are not orphans
+	late, err := store.SaveFrame(FrameInput{FrameID: "late"}, bytes.NewReader(testImage(t, "png", "late")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rep, err := ro.Scrub(false)
+	if err != nil || rep.Checked != 1 || len(rep.Issues) != 0 {
+		t.Fatalf("unexpected read-only report %+v err=%v (late frame %s)", rep, err, late.FileName)
+	}
+	if _, err := ro.Scrub(true); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("expected ErrReadOnly, got %v", err)
+	}
+}
+
+func TestScrubRechecksOrphans(t *testing.T) {
+	dir := t.TempDir()
+	store := newTestStore(t, dir)
+	store.orphanRecheck = 200 * time.Millisecond
+	stray := filepath.Join(dir, "frames", "pending.png")
+	data := testImage(t, "png", "pending")
+	if err := os.WriteFile(stray, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(stray, old, old); err != nil {
+		t.Fatal(err)
+	}
+	recent := filepath.Join(dir, "frames", "recent.png")
+	if err := os.WriteFile(recent, testImage(t, "png", "recent"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// a commit in flight: renamed into place long after it was spooled,
+	// indexed while the scrub waits
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		store.mu.Lock()
+		sum := sha256.Sum256(data)
+		m := &FrameMeta{FileName: "pending.png", SHA256: hex.EncodeToString(sum[:])}
+		store.frames = append(store.frames, m)
+		store.byName[m.FileName] = m
+		store.mu.Unlock()
+	}()
+	rep, err := store.Scrub(true)
+	if err != nil || rep.Orphaned != 0 {
+		t.Fatalf("expected no orphans: %+v err=%v", rep, err)
+	}
+	for _, p := range []string{stray, recent} {
+		if _, err := os.Stat(p); err != nil {
+			t.Fatalf("%s was quarantined: %v", p, err)
+		}
+	}
+}
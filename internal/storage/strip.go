@@ -0,0 +1,247 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+)
+
+var errTruncated = errors.New("truncated image")
+
+// MetadataStripper removes EXIF, XMP, comments and other metadata segments
+// from JPEG frames and text, EXIF and time chunks from PNG frames. The
+// remaining segments are copied verbatim, so pixels are never re-encoded.
+// An EXIF orientation other than the default is kept, alone in a minimal
+// EXIF block, so viewers still rotate the frame upright.
+// It records the SHA-256 of the bytes as uploaded in OriginalSHA256; SHA256
+// and Size describe the stored bytes. Other formats are stored unchanged.
+// Register it before any other stage so they all see the stripped frame.
+type MetadataStripper struct{}
+
+func NewMetadataStripper() *MetadataStripper {
+	return &MetadataStripper{}
+}
+
+func (MetadataStripper) Process(f *PendingFrame) error {
+	var strip func([]byte) ([]byte, error)
+	switch f.Meta.Format {
+	case "jpeg":
+		strip = stripJPEG
+	case "png":
+		strip = stripPNG
+	default:
+		return nil
+	}
+	// frames are bounded by the upload size limit
+	data, err := os.ReadFile(f.SpoolPath)
+	if err != nil {
+		return fmt.Errorf("read spool file: %w", err)
+	}
+	clean, err := strip(data)
+	if err != nil {
+		return fmt.Errorf("%w: strip %s metadata: %v", ErrInvalidPayload, f.Meta.Format, err)
+	}
+	f.Meta.OriginalSHA256 = f.Meta.SHA256
+	f.Meta.MetadataStripped = true
+	if bytes.Equal(clean, data) {
+		return nil
+	}
+	if err := replaceFile(f.SpoolPath, clean); err != nil {
+		return fmt.Errorf("rewrite spool file: %w", err)
+	}
+	sum := sha256.Sum256(clean)
+	f.Meta.SHA256 = hex.EncodeToString(sum[:])
+	f.Meta.Size = int64(len(clean))
+	return nil
+}
+
+// stripJPEG keeps the segments needed to decode and render the image: the
+// frame, table and scan segments, the JFIF header, the ICC colour profile
+// and the Adobe colour transform. Every other APPn segment (EXIF and XMP
+// live in APP1), comments and anything after EOI are dropped; the EXIF
+// orientation survives in a minimal APP1 of its own.
+func stripJPEG(data []byte) ([]byte, error) {
+	oriented := false
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil, errors.New("missing SOI marker")
+	}
+	out := make([]byte, 0, len(data))
+	out = append(out, 0xff, 0xd8)
+	i := 2
+	for {
+		if i >= len(data) {
+			return nil, errTruncated
+		}
+		if data[i] != 0xff {
+			return nil, fmt.Errorf("expected marker at offset %d", i)
+		}
+		for i < len(data) && data[i] == 0xff {
+			i++
+		}
+		if i >= len(data) {
+			return nil, errTruncated
+		}
+		marker := data[i]
+		i++
+		switch {
+		case marker == 0xd9:
+			return append(out, 0xff, 0xd9), nil
+		case marker == 0x01 || marker >= 0xd0 && marker <= 0xd7:
+			out = append(out, 0xff, marker)
+			continue
+		}
+		if i+2 > len(data) {
+			return nil, errTruncated
+		}
+		length := int(binary.BigEndian.Uint16(data[i:]))
+		if length < 2 || i+length > len(data) {
+			return nil, errTruncated
+		}
+		segment := data[i : i+length]
+		i += length
+		switch {
+		case keepJPEGSegment(marker, segment[2:]):
+			out = append(out, 0xff, marker)
+			out = append(out, segment...)
+		case marker == 0xe1 && !oriented && bytes.HasPrefix(segment[2:], exifHeader):
+			if tiff := orientationEXIF(segment[2+len(exifHeader):]); tiff != nil {
+				out = append(out, 0xff, 0xe1, 0, 0)
+				binary.BigEndian.PutUint16(out[len(out)-2:], uint16(2+len(exifHeader)+len(tiff)))
+				out = append(out, exifHeader...)
+				out = append(out, tiff...)
+				oriented = true
+			}
+		}
+		if marker != 0xda {
+			continue
+		}
+		// entropy-coded data runs until a marker other than RSTn; 0xff
+		// bytes inside it are stuffed with 0x00
+		j := i
+		for ; j+1 < len(data); j++ {
+			if next := data[j+1]; data[j] == 0xff && next != 0x00 && (next < 0xd0 || next > 0xd7) {
+				break
+			}
+		}
+		if j+1 >= len(data) {
+			return nil, errTruncated
+		}
+		out = append(out, data[i:j]...)
+		i = j
+	}
+}
+
+func keepJPEGSegment(marker byte, payload []byte) bool {
+	switch marker {
+	case 0xe0:
+		return bytes.HasPrefix(payload, []byte("JFIF\x00"))
+	case 0xe2:
+		return bytes.HasPrefix(payload, []byte("ICC_PROFILE\x00"))
+	case 0xee:
+		return bytes.HasPrefix(payload, []byte("Adobe"))
+	case 0xfe:
+		return false
+	}
+	return marker < 0xe0 || marker > 0xef
+}
+
+// pngMetadataChunks are ancillary chunks that carry free text, EXIF or the
+// modification time; none of them affects how the image is rendered.
+var pngMetadataChunks = map[string]bool{"tEXt": true, "zTXt": true, "iTXt": true, "eXIf": true, "tIME": true}
+
+// stripPNG drops metadata chunks and anything after IEND. Chunks carry
+// their own CRC, so the kept ones are copied unchanged. An eXIf chunk with
+// an orientation is replaced by one holding only the orientation.
+func stripPNG(data []byte) ([]byte, error) {
+	const sigLen = 8
+	if len(data) < sigLen {
+		return nil, errTruncated
+	}
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:sigLen]...)
+	for i := sigLen; ; {
+		if i+8 > len(data) {
+			return nil, errTruncated
+		}
+		n := int64(binary.BigEndian.Uint32(data[i:]))
+		end := int64(i) + 12 + n
+		if end > int64(len(data)) {
+			return nil, errTruncated
+		}
+		typ := string(data[i+4 : i+8])
+		switch {
+		case !pngMetadataChunks[typ]:
+			out = append(out, data[i:end]...)
+		case typ == "eXIf":
+			if tiff := orientationEXIF(data[i+8 : end-4]); tiff != nil {
+				out = binary.BigEndian.AppendUint32(out, uint32(len(tiff)))
+				out = append(out, typ...)
+				out = append(out, tiff...)
+				out = binary.BigEndian.AppendUint32(out, crc32.ChecksumIEEE(append([]byte(typ), tiff...)))
+			}
+		}
+		i = int(end)
+		if typ == "IEND" {
+			return out, nil
+		}
+	}
+}
+
+// exifHeader precedes the TIFF structure in a JPEG APP1 segment.
+var exifHeader = []byte("Exif\x00\x00")
+
+const exifOrientationTag = 0x0112
+
+// orientationEXIF reads the Orientation tag from the first IFD of a TIFF
+// structure and returns a new TIFF structure, in the same byte order,
+// holding only that tag. It returns nil when there is no orientation, it
+// is the default (1) or the structure cannot be read.
+func orientationEXIF(tiff []byte) []byte {
+	if len(tiff) < 8 {
+		return nil
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil
+	}
+	if order.Uint16(tiff[2:]) != 42 {
+		return nil
+	}
+	ifd := int64(order.Uint32(tiff[4:]))
+	if ifd+2 > int64(len(tiff)) {
+		return nil
+	}
+	n := int64(order.Uint16(tiff[ifd:]))
+	var orientation uint16
+	for e := ifd + 2; e < ifd+2+12*n && e+12 <= int64(len(tiff)); e += 12 {
+		// a SHORT with count 1 is stored in the first bytes of the value
+		if order.Uint16(tiff[e:]) == exifOrientationTag && order.Uint16(tiff[e+2:]) == 3 && order.Uint32(tiff[e+4:]) == 1 {
+			orientation = order.Uint16(tiff[e+8:])
+			break
+		}
+	}
+	if orientation < 2 || orientation > 8 {
+		return nil
+	}
+	// header, IFD0 at offset 8 with one entry, no next IFD
+	out := make([]byte, 26)
+	copy(out, tiff[:2])
+	order.PutUint16(out[2:], 42)
+	order.PutUint32(out[4:], 8)
+	order.PutUint16(out[8:], 1)
+	order.PutUint16(out[10:], exifOrientationTag)
+	order.PutUint16(out[12:], 3)
+	order.PutUint32(out[14:], 1)
+	order.PutUint16(out[18:], orientation)
+	return out
+}
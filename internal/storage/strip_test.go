@@ -0,0 +1,204 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"hash/crc32"
+	"image"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// exifJPEG inserts an EXIF APP1 segment with a GPS tag, a comment and
+// trailing bytes into an encoded JPEG.
+func exifJPEG(t *testing.T, seed string) []byte {
+	t.Helper()
+	src := testImage(t, "jpeg", seed)
+	segment := func(marker byte, payload string) []byte {
+		b := []byte{0xff, marker, 0, 0}
+		binary.BigEndian.PutUint16(b[2:], uint16(len(payload)+2))
+		return append(b, payload...)
+	}
+	out := append([]byte{}, src[:2]...)
+	out = append(out, segment(0xe1, "Exif\x00\x00GPSLatitude=45.4642")...)
+	out = append(out, segment(0xfe, "serial SN-12345")...)
+	out = append(out, src[2:]...)
+	return append(out, "trailer"...)
+}
+
+func textPNG(t *testing.T, seed string) []byte {
+	t.Helper()
+	src := testImage(t, "png", seed)
+	chunk := func(typ, data string) []byte {
+		b := make([]byte, 4, 12+len(data))
+		binary.BigEndian.PutUint32(b, uint32(len(data)))
+		b = append(b, typ...)
+		b = append(b, data...)
+		return binary.BigEndian.AppendUint32(b, crc32.ChecksumIEEE([]byte(typ+data)))
+	}
+	iend := len(src) - 12
+	out := append([]byte{}, src[:iend]...)
+	out = append(out, chunk("tEXt", "Comment\x00GPSLatitude=45.4642")...)
+	return append(out, src[iend:]...)
+}
+
+func decodePixels(t *testing.T, data []byte) image.Image {
+	t.Helper()
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+func TestStripMetadata(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		data  []byte
+		strip func([]byte) ([]byte, error)
+	}{
+		{"jpeg", exifJPEG(t, "scene"), stripJPEG},
+		{"png", textPNG(t, "scene"), stripPNG},
+	} {
+		clean, err := tc.strip(tc.data)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		for _, leak := range []string{"GPSLatitude", "SN-12345", "trailer"} {
+			if bytes.Contains(clean, []byte(leak)) {
+				t.Fatalf("%s: %q survived stripping", tc.name, leak)
+			}
+		}
+		if !reflect.DeepEqual(decodePixels(t, clean), decodePixels(t, tc.data)) {
+			t.Fatalf("%s: pixels changed", tc.name)
+		}
+		if again, _ := tc.strip(clean); !bytes.Equal(again, clean) {
+			t.Fatalf("%s: stripping is not idempotent", tc.name)
+		}
+	}
+	plain := testImage(t, "jpeg", "plain")
+	if clean, err := stripJPEG(plain); err != nil || !bytes.Equal(clean, plain) {
+		t.Fatalf("expected a JPEG without metadata to be unchanged, err=%v", err)
+	}
+	if _, err := stripJPEG(plain[:len(plain)/2]); err == nil {
+		t.Fatal("expected an error for a truncated JPEG")
+	}
+}
+
+func TestMetadataStripperStage(t *testing.T) {
+	dir := t.TempDir()
+	store := newTestStore(t, dir)
+	store.Use(NewMetadataStripper())
+	payload := exifJPEG(t, "street")
+	in := FrameInput{FrameID: "cam", IdempotencyKey: "k1"}
+	meta, err := store.SaveFrame(in, bytes.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	uploaded := sha256.Sum256(payload)
+	if !meta.MetadataStripped || meta.OriginalSHA256 != hex.EncodeToString(uploaded[:]) || meta.Size >= int64(len(payload)) {
+		t.Fatalf("unexpected meta: %+v", meta)
+	}
+	onDisk, err := os.ReadFile(filepath.Join(dir, "frames", filepath.FromSlash(meta.FileName)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := sha256.Sum256(onDisk)
+	if meta.SHA256 != hex.EncodeToString(stored[:]) || int64(len(onDisk)) != meta.Size || bytes.Contains(onDisk, []byte("GPSLatitude")) {
+		t.Fatalf("stored bytes do not match the metadata: %+v", meta)
+	}
+
+	// a retry carries the original bytes and must still be a duplicate
+	again, err := store.SaveFrame(in, bytes.NewReader(payload))
+	if err != nil || !again.Duplicate || again.FileName != meta.FileName {
+		t.Fatalf("expected a duplicate, got %+v err=%v", again, err)
+	}
+	var conflict *IdempotencyConflictError
+	if _, err := store.SaveFrame(in, bytes.NewReader(exifJPEG(t, "other"))); !errors.As(err, &conflict) || conflict.StoredSHA256 != meta.OriginalSHA256 {
+		t.Fatalf("expected a conflict against the uploaded digest, got %v", err)
+	}
+}
+
+type byteOrder interface {
+	binary.ByteOrder
+	binary.AppendByteOrder
+}
+
+// orientedTIFF is an EXIF TIFF structure with a Make tag pointing at a
+// string and the given orientation.
+func orientedTIFF(order byteOrder, orientation uint16) []byte {
+	b := []byte("MM")
+	if order == binary.LittleEndian {
+		b = []byte("II")
+	}
+	b = order.AppendUint16(b, 42)
+	b = order.AppendUint32(b, 8)
+	b = order.AppendUint16(b, 2)
+	maker := "SN-12345\x00"
+	b = order.AppendUint16(b, 0x010f)
+	b = order.AppendUint16(b, 2)
+	b = order.AppendUint32(b, uint32(len(maker)))
+	b = order.AppendUint32(b, 8+2+2*12+4)
+	b = order.AppendUint16(b, 0x0112)
+	b = order.AppendUint16(b, 3)
+	b = order.AppendUint32(b, 1)
+	b = order.AppendUint16(b, orientation)
+	b = append(b, 0, 0, 0, 0, 0, 0)
+	return append(b, maker...)
+}
+
+func TestStripKeepsOrientation(t *testing.T) {
+	for _, order := range []byteOrder{binary.BigEndian, binary.LittleEndian} {
+		tiff := orientedTIFF(order, 6)
+		src := testImage(t, "jpeg", "rotated")
+		app1 := []byte{0xff, 0xe1, 0, 0}
+		binary.BigEndian.PutUint16(app1[2:], uint16(2+6+len(tiff)))
+		app1 = append(append(app1, "Exif\x00\x00"...), tiff...)
+		data := append(append(append([]byte{}, src[:2]...), app1...), src[2:]...)
+
+		clean, err := stripJPEG(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(clean, []byte("SN-12345")) {
+			t.Fatalf("%v: EXIF tags other than the orientation survived", order)
+		}
+		kept := clean[2:]
+		if kept[0] != 0xff || kept[1] != 0xe1 || !bytes.HasPrefix(kept[4:], []byte("Exif\x00\x00")) {
+			t.Fatalf("%v: expected a leading EXIF APP1, got % x", order, kept[:12])
+		}
+		if got := orientationEXIF(kept[10:]); got == nil || order.Uint16(got[18:]) != 6 {
+			t.Fatalf("%v: orientation not kept", order)
+		}
+		if again, _ := stripJPEG(clean); !bytes.Equal(again, clean) {
+			t.Fatalf("%v: stripping is not idempotent", order)
+		}
+	}
+
+	// the default orientation carries no information and is dropped
+	upright := orientedTIFF(binary.BigEndian, 1)
+	if got := orientationEXIF(upright); got != nil {
+		t.Fatalf("expected no EXIF for orientation 1, got % x", got)
+	}
+
+	png := testImage(t, "png", "rotated")
+	tiff := string(orientedTIFF(binary.LittleEndian, 8))
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(tiff)))
+	chunk = append(chunk, "eXIf"+tiff...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE([]byte("eXIf"+tiff)))
+	iend := len(png) - 12
+	data := append(append(append([]byte{}, png[:iend]...), chunk...), png[iend:]...)
+	clean, err := stripPNG(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(clean, []byte("SN-12345")) || !bytes.Contains(clean, []byte("eXIf")) {
+		t.Fatal("expected an eXIf chunk with only the orientation")
+	}
+	decodePixels(t, clean)
+}
@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"io"
+)
+
+const thumbnailSuffix = ".thumb.jpg"
+
+type Thumbnailer struct {
+	MaxDim  int
+	Quality int
+}
+
+func NewThumbnailer(maxDim, quality int) *Thumbnailer {
+	if quality <= 0 || quality > 100 {
+		quality = jpeg.DefaultQuality
+	}
+	return &Thumbnailer{MaxDim: maxDim, Quality: quality}
+}
+
+func (t *Thumbnailer) Process(f *PendingFrame) error {
+	img, err := f.Image()
+	if err != nil {
+		// thumbnails are best effort: formats without a decoder (WebP) or
+		// damaged pixel data still store the original frame
+		return nil
+	}
+	thumb := downscale(img, t.MaxDim)
+	name, err := f.Attach(thumbnailSuffix, "image/jpeg", func(w io.Writer) error {
+		return jpeg.Encode(w, thumb, &jpeg.Options{Quality: t.Quality})
+	})
+	if err != nil {
+		return err
+	}
+	f.Meta.ThumbnailFileName = name
+	f.Meta.ThumbnailPath = f.store.backend.Location(name)
+	return nil
+}
+
+// downscale box-filters src so that its longest side is at most maxDim,
+// preserving the aspect ratio. Images already within bounds are only
+// converted to RGBA.
+func downscale(src image.Image, maxDim int) *image.RGBA {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	rgba := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(rgba, rgba.Bounds(), src, b.Min, draw.Src)
+	tw, th := w, h
+	switch {
+	case w >= h && w > maxDim:
+		tw, th = maxDim, max(1, h*maxDim/w)
+	case h > w && h > maxDim:
+		tw, th = max(1, w*maxDim/h), maxDim
+	default:
+		return rgba
+	}
+	return resizeBox(rgba, tw, th)
+}
+
+func resizeBox(src *image.RGBA, tw, th int) *image.RGBA {
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for y := 0; y < th; y++ {
+		y0 := y * h / th
+		y1 := max((y+1)*h/th, y0+1)
+		for x := 0; x < tw; x++ {
+			x0 := x * w / tw
+			x1 := max((x+1)*w/tw, x0+1)
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					sum[0] += int(p[0])
+					sum[1] += int(p[1])
+					sum[2] += int(p[2])
+					sum[3] += int(p[3])
+				}
+			}
+			n := (y1 - y0) * (x1 - x0)
+			d := dst.Pix[y*dst.Stride+x*4:]
+			for c := 0; c < 4; c++ {
+				d[c] = uint8(sum[c] / n)
+			}
+		}
+	}
+	return dst
+}
@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDownscalePreservesAspectRatio(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 400, 100))
+	got := downscale(src, 100)
+	if got.Bounds().Dx() != 100 || got.Bounds().Dy() != 25 {
+		t.Fatalf("unexpected thumbnail size: %v", got.Bounds())
+	}
+	small := downscale(image.NewRGBA(image.Rect(0, 0, 20, 40)), 100)
+	if small.Bounds().Dx() != 20 || small.Bounds().Dy() != 40 {
+		t.Fatalf("small images must not be upscaled: %v", small.Bounds())
+	}
+}
+
+func TestThumbnailGeneratedAndRemovedWithFrame(t *testing.T) {
+	store := newTestStore(t, t.TempDir())
+	store.Use(NewThumbnailer(32, 80))
+
+	img := image.NewRGBA(image.Rect(0, 0, 128, 64))
+	for x := 0; x < 128; x++ {
+		img.Set(x, 10, color.RGBA{R: 255, A: 255})
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	meta, err := store.SaveFrame(FrameInput{FrameID: "f", ContentType: "image/png"}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.ThumbnailFileName != meta.FileName+thumbnailSuffix {
+		t.Fatalf("unexpected thumbnail name: %+v", meta)
+	}
+	_, f, err := store.OpenThumbnail(meta.FileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := jpeg.DecodeConfig(f)
+	f.Close()
+	if err != nil || cfg.Width != 32 || cfg.Height != 16 {
+		t.Fatalf("unexpected thumbnail: %+v err=%v", cfg, err)
+	}
+
+	if _, err := store.ApplyRetention(RetentionPolicy{MaxAge: time.Minute}, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(meta.ThumbnailPath); !os.IsNotExist(err) {
+		t.Fatalf("expected thumbnail to be removed with its frame, err=%v", err)
+	}
+}
@@ -0,0 +1,161 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"ermete/internal/config"
+)
+
+var ErrInvalidTimestamp = fmt.Errorf("%w: invalid timestamp", ErrInvalidPayload)
+
+// Capture times before minCaptureTime or more than maxClockAhead past the
+// server clock are treated like unparseable ones: no camera clock that far
+// off is worth trusting, and small epoch numbers parse as early 1970.
+var minCaptureTime = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+const maxClockAhead = 24 * time.Hour
+
+// maxSkewDevices bounds the device_id label of the per-device skew gauge;
+// the least recently seen device loses its series first.
+const maxSkewDevices = 256
+
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999Z07:00",
+	time.RFC1123Z,
+	time.RFC1123,
+	// without a zone the client clock is assumed to be UTC; a local clock
+	// shows up as skew
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999",
+}
+
+// ParseCaptureTime parses a client timestamp: RFC 3339 (also with a space
+// instead of T, or without a zone, taken as UTC), RFC 1123, or a Unix epoch
+// in seconds (optionally with a fraction), milliseconds, microseconds or
+// nanoseconds, told apart by magnitude. The result is in UTC.
+func ParseCaptureTime(raw string) (time.Time, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return time.Time{}, errors.New("empty timestamp")
+	}
+	if raw[0] >= '0' && raw[0] <= '9' && !strings.ContainsAny(raw, "-:") {
+		return parseEpoch(raw)
+	}
+	for _, layout := range timestampLayouts {
+		if t, err := time.Parse(layout, raw); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognised timestamp %q", raw)
+}
+
+func parseEpoch(raw string) (time.Time, error) {
+	whole, frac, hasFrac := strings.Cut(raw, ".")
+	n, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil || n <= 0 {
+		return time.Time{}, fmt.Errorf("invalid epoch timestamp %q", raw)
+	}
+	if hasFrac {
+		if frac == "" || len(frac) > 9 || n >= 1e11 {
+			return time.Time{}, fmt.Errorf("invalid epoch timestamp %q", raw)
+		}
+		nanos, err := strconv.ParseInt(frac+strings.Repeat("0", 9-len(frac)), 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid epoch timestamp %q", raw)
+		}
+		return time.Unix(n, nanos).UTC(), nil
+	}
+	switch {
+	case n < 1e11:
+		return time.Unix(n, 0).UTC(), nil
+	case n < 1e14:
+		return time.UnixMilli(n).UTC(), nil
+	case n < 1e17:
+		return time.UnixMicro(n).UTC(), nil
+	default:
+		return time.Unix(0, n).UTC(), nil
+	}
+}
+
+// CaptureTime is when the client says the frame was taken. Frames indexed
+// before CapturedAt existed fall back to parsing Timestamp, and frames
+// without a usable timestamp to ReceivedAt.
+func (m FrameMeta) CaptureTime() time.Time {
+	if m.CapturedAt != nil {
+		return *m.CapturedAt
+	}
+	if !m.TimestampInvalid && m.Timestamp != "" {
+		if t, err := ParseCaptureTime(m.Timestamp); err == nil {
+			return t
+		}
+	}
+	return m.ReceivedAt
+}
+
+// parseTimestamp applies the store's timestamp policy to the X-Timestamp of
+// an upload. An empty value has no capture time and is not invalid; an
+// unparseable or implausible one is flagged or, with TimestampPolicyReject,
+// an error.
+func (s *FrameStore) parseTimestamp(raw string) (captured *time.Time, invalid bool, err error) {
+	if raw == "" {
+		return nil, false, nil
+	}
+	t, perr := ParseCaptureTime(raw)
+	if perr == nil {
+		perr = checkCaptureTime(t, time.Now())
+	}
+	switch {
+	case perr == nil:
+		return &t, false, nil
+	case s.timestampPolicy == config.TimestampPolicyReject:
+		return nil, true, fmt.Errorf("%w: %v", ErrInvalidTimestamp, perr)
+	default:
+		return nil, true, nil
+	}
+}
+
+func checkCaptureTime(t, now time.Time) error {
+	switch {
+	case t.Before(minCaptureTime):
+		return fmt.Errorf("timestamp %s before %s", t.Format(time.RFC3339), minCaptureTime.Format(time.RFC3339))
+	case t.After(now.Add(maxClockAhead)):
+		return fmt.Errorf("timestamp %s more than %s in the future", t.Format(time.RFC3339), maxClockAhead)
+	}
+	return nil
+}
+
+func (s *FrameStore) recordTimestamp(meta FrameMeta) {
+	if s.metrics == nil {
+		return
+	}
+	if meta.TimestampInvalid {
+		s.metrics.InvalidTimestampsTotal.Inc()
+	}
+	if meta.ClockSkewSeconds == nil {
+		return
+	}
+	skew := *meta.ClockSkewSeconds
+	s.metrics.ClockSkew.Observe(skew)
+	device := meta.DeviceID
+	if device == "" {
+		return
+	}
+	s.skewMu.Lock()
+	defer s.skewMu.Unlock()
+	if elem, ok := s.skewDevices[device]; ok {
+		s.skewOrder.MoveToBack(elem)
+	} else {
+		s.skewDevices[device] = s.skewOrder.PushBack(device)
+		for len(s.skewDevices) > maxSkewDevices {
+			oldest, _ := s.skewOrder.Remove(s.skewOrder.Front()).(string)
+			delete(s.skewDevices, oldest)
+			s.metrics.ClientClockSkew.DeleteLabelValues(oldest)
+		}
+	}
+	s.metrics.ClientClockSkew.WithLabelValues(device).Set(skew)
+}
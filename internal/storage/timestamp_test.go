@@ -0,0 +1,198 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"ermete/internal/config"
+	"ermete/internal/observability"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestParseCaptureTime(t *testing.T) {
+	want := time.Date(2026, 10, 16, 8, 30, 15, 250_000_000, time.UTC)
+	for _, raw := range []string{
+		"2026-10-16T08:30:15.25Z",
+		"2026-10-16T10:30:15.25+02:00",
+		"2026-10-16 08:30:15.25Z",
+		"2026-10-16T08:30:15.25",
+		"1792139415.25",
+		"1792139415250",
+		"1792139415250000",
+		"1792139415250000000",
+	} {
+		got, err := ParseCaptureTime(raw)
+		if err != nil || !got.Equal(want) || got.Location() != time.UTC {
+			t.Fatalf("%s: got %v err=%v", raw, got, err)
+		}
+	}
+	if got, err := ParseCaptureTime("Fri, 16 Oct 2026 08:30:15 GMT"); err != nil || !got.Equal(want.Truncate(time.Second)) {
+		t.Fatalf("RFC 1123: got %v err=%v", got, err)
+	}
+	if got, err := ParseCaptureTime("1792139415"); err != nil || !got.Equal(want.Truncate(time.Second)) {
+		t.Fatalf("epoch seconds: got %v err=%v", got, err)
+	}
+	for _, bad := range []string{"", "yesterday", "0", "-5", "12.", "1792139415.1234567891", "2026-13-01T00:00:00Z"} {
+		if _, err := ParseCaptureTime(bad); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestTimestampPolicyAndSkew(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	store, err := OpenFrameStore(Options{DataDir: t.TempDir(), Metrics: observability.NewMetrics(reg)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	captured := time.Now().Add(-90 * time.Second).UTC()
+	meta, err := store.SaveFrame(FrameInput{FrameID: "a", Timestamp: captured.Format(time.RFC3339Nano), ClientSessionID: "session-1", DeviceID: "phone-1"}, bytes.NewReader(testImage(t, "png", "a")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.CapturedAt == nil || !meta.CapturedAt.Equal(captured) || meta.ClockSkewSeconds == nil || *meta.ClockSkewSeconds < 89 || *meta.ClockSkewSeconds > 120 {
+		t.Fatalf("unexpected capture metadata: %+v", meta)
+	}
+	if got := testGaugeVecValue(t, reg, "ermete_client_clock_skew_seconds", "phone-1"); got != *meta.ClockSkewSeconds {
+		t.Fatalf("expected per-client skew %v, got %v", *meta.ClockSkewSeconds, got)
+	}
+
+	flagged, err := store.SaveFrame(FrameInput{FrameID: "b", Timestamp: "last tuesday"}, bytes.NewReader(testImage(t, "png", "b")))
+	if err != nil || !flagged.TimestampInvalid || flagged.CapturedAt != nil || flagged.Timestamp != "last tuesday" {
+		t.Fatalf("expected a flagged frame: %+v err=%v", flagged, err)
+	}
+	if got := counterValue(t, reg, "ermete_frames_invalid_timestamp_total"); got != 1 {
+		t.Fatalf("expected 1 invalid timestamp, got %v", got)
+	}
+	if !flagged.CaptureTime().Equal(flagged.ReceivedAt) {
+		t.Fatal("expected a flagged frame to fall back to its received time")
+	}
+
+	// parseable but implausible: an epoch of 12 seconds, two days ahead
+	ahead := time.Now().Add(48 * time.Hour).UTC().Format(time.RFC3339)
+	for i, raw := range []string{"12", ahead} {
+		implausible, err := store.SaveFrame(FrameInput{FrameID: "d", Timestamp: raw}, bytes.NewReader(testImage(t, "png", "d"+raw)))
+		if err != nil || !implausible.TimestampInvalid || implausible.CapturedAt != nil {
+			t.Fatalf("%s: expected a flagged frame: %+v err=%v", raw, implausible, err)
+		}
+		if got := counterValue(t, reg, "ermete_frames_invalid_timestamp_total"); got != float64(2+i) {
+			t.Fatalf("%s: expected %d invalid timestamps, got %v", raw, 2+i, got)
+		}
+	}
+
+	store.timestampPolicy = config.TimestampPolicyReject
+	for _, raw := range []string{"last tuesday", "1999-12-31T23:59:59Z", ahead} {
+		_, err = store.SaveFrame(FrameInput{FrameID: "c", Timestamp: raw}, bytes.NewReader(testImage(t, "png", "c")))
+		if !errors.Is(err, ErrInvalidTimestamp) || !errors.Is(err, ErrInvalidPayload) {
+			t.Fatalf("%s: expected ErrInvalidTimestamp, got %v", raw, err)
+		}
+	}
+}
+
+func TestListFramesInCaptureOrder(t *testing.T) {
+	dir := t.TempDir()
+	store := newTestStore(t, dir)
+	// uploaded out of order, as a phone flushing its backlog would
+	for _, ts := range []string{"1767229200000", "2026-01-01T00:00:00Z", "2025-12-31T23:30:00Z"} {
+		if _, err := store.SaveFrame(FrameInput{FrameID: ts, Timestamp: ts}, bytes.NewReader(testImage(t, "png", ts))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var got []string
+	q := FrameQuery{TimeField: TimeFieldTimestamp, Limit: 1}
+	for {
+		page, err := store.ListFrames(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range page.Frames {
+			got = append(got, m.FrameID)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		q.Cursor = page.NextCursor
+	}
+	want := []string{"2025-12-31T23:30:00Z", "2026-01-01T00:00:00Z", "1767229200000"}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+		t.Fatalf("expected capture order %v, got %v", want, got)
+	}
+
+	until := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	page, err := store.ListFrames(FrameQuery{TimeField: TimeFieldTimestamp, Until: until})
+	if err != nil || len(page.Frames) != 2 {
+		t.Fatalf("expected 2 frames captured by %v, got %+v err=%v", until, page.Frames, err)
+	}
+	store.Close()
+
+	reopened := newTestStore(t, dir)
+	page, err = reopened.ListFrames(FrameQuery{TimeField: TimeFieldTimestamp})
+	if err != nil || len(page.Frames) != 3 || page.Frames[0].FrameID != want[0] {
+		t.Fatalf("capture order lost after restart: %+v err=%v", page.Frames, err)
+	}
+}
+
+func testGaugeVecValue(t *testing.T, reg *prometheus.Registry, name, label string) float64 {
+	t.Helper()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range families {
+		if f.GetName() != name {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetValue() == label {
+					return m.GetGauge().GetValue()
+				}
+			}
+		}
+	}
+	t.Fatalf("metric %s{%s} not found", name, label)
+	return 0
+}
+
+func TestClockSkewDeviceEviction(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	store, err := OpenFrameStore(Options{DataDir: t.TempDir(), Metrics: observability.NewMetrics(reg)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	skew := 1.5
+	record := func(device string) {
+		store.recordTimestamp(FrameMeta{DeviceID: device, ClockSkewSeconds: &skew})
+	}
+	for i := 0; i < maxSkewDevices; i++ {
+		record(fmt.Sprintf("dev-%d", i))
+	}
+	// dev-0 reports again, so dev-1 is now the least recently seen
+	record("dev-0")
+	record("dev-new")
+	series := func() map[string]bool {
+		families, err := reg.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := map[string]bool{}
+		for _, f := range families {
+			if f.GetName() == "ermete_client_clock_skew_seconds" {
+				for _, m := range f.GetMetric() {
+					out[m.GetLabel()[0].GetValue()] = true
+				}
+			}
+		}
+		return out
+	}()
+	if len(series) != maxSkewDevices || series["dev-1"] || !series["dev-0"] || !series["dev-new"] {
+		t.Fatalf("expected dev-1 evicted and %d series, got %d (dev-0 %v, dev-new %v)", maxSkewDevices, len(series), series["dev-0"], series["dev-new"])
+	}
+}
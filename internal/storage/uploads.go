@@ -0,0 +1,427 @@
+package storage
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"ermete/internal/observability"
+)
+
+var (
+	ErrUploadNotFound   = errors.New("upload not found")
+	ErrUploadIncomplete = errors.New("upload incomplete")
+	ErrUploadCompleted  = errors.New("upload already finalized")
+	ErrUploadBusy       = errors.New("upload busy with another request")
+)
+
+type UploadOffsetError struct {
+	Offset int64
+}
+
+func (e *UploadOffsetError) Error() string {
+	return fmt.Sprintf("upload offset mismatch, current offset is %d", e.Offset)
+}
+
+// Upload is a resumable upload. Length is the declared total size, 0 when
+// unknown. Frame is set once the upload has been finalized.
+type Upload struct {
+	ID              string     `json:"upload_id"`
+	FrameID         string     `json:"frame_id,omitempty"`
+	Timestamp       string     `json:"timestamp,omitempty"`
+	IdempotencyKey  string     `json:"idempotency_key,omitempty"`
+	ContentType     string     `json:"content_type,omitempty"`
+	SessionID       string     `json:"session_id,omitempty"`
+	ClientSessionID string     `json:"client_session_id,omitempty"`
+	DeviceID        string     `json:"device_id,omitempty"`
+	Length          int64      `json:"length,omitempty"`
+	Offset          int64      `json:"offset"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+	ExpiresAt       time.Time  `json:"expires_at"`
+	Frame           *FrameMeta `json:"frame,omitempty"`
+}
+
+// pendingUpload is guarded by two locks. write is held for the whole of a
+// PATCH or finalize, which may stream for as long as the client keeps the
+// connection open; mu only guards info and is never held across I/O on the
+// request body, so Get answers at once with the committed offset.
+type pendingUpload struct {
+	write   sync.Mutex
+	mu      sync.Mutex
+	info    Upload
+	removed bool
+	// done mirrors info.Frame != nil under UploadStore.mu for the metrics
+	done bool
+}
+
+// UploadStore keeps partially received frames under DATA_DIR/uploads as an
+// <id>.json descriptor plus an <id>.part data file, so an upload survives
+// restarts and resumes from the last byte written to disk. Finalized uploads
+// are handed to FrameStore.SaveFrame and kept as a descriptor until they
+// expire, so a retried finalize returns the same frame.
+type UploadStore struct {
+	dir      string
+	frames   *FrameStore
+	maxBytes int64
+	expiry   time.Duration
+
+	mu      sync.Mutex
+	uploads map[string]*pendingUpload
+	metrics *observability.Metrics
+}
+
+func NewUploadStore(dataDir string, frames *FrameStore, maxBytes int64, expiry time.Duration, metrics *observability.Metrics) (*UploadStore, error) {
+	if expiry <= 0 {
+		expiry = 24 * time.Hour
+	}
+	dir := filepath.Join(dataDir, "uploads")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create uploads dir: %w", err)
+	}
+	u := &UploadStore{dir: dir, frames: frames, maxBytes: maxBytes, expiry: expiry, uploads: map[string]*pendingUpload{}, metrics: metrics}
+	if err := u.load(); err != nil {
+		return nil, err
+	}
+	u.updateMetricsLocked()
+	go u.expireLoop()
+	return u, nil
+}
+
+func (u *UploadStore) load() error {
+	matches, err := filepath.Glob(filepath.Join(u.dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, m := range matches {
+		data, err := os.ReadFile(m)
+		if err != nil {
+			return fmt.Errorf("load upload: %w", err)
+		}
+		var info Upload
+		if err := json.Unmarshal(data, &info); err != nil || info.ID != strings.TrimSuffix(filepath.Base(m), ".json") {
+			// a torn descriptor cannot be resumed
+			u.removeFiles(strings.TrimSuffix(filepath.Base(m), ".json"))
+			continue
+		}
+		if info.Frame == nil {
+			st, err := os.Stat(u.partPath(info.ID))
+			if err != nil {
+				u.removeFiles(info.ID)
+				continue
+			}
+			info.Offset = st.Size()
+			info.UpdatedAt = st.ModTime().UTC()
+		}
+		info.ExpiresAt = info.UpdatedAt.Add(u.expiry)
+		u.uploads[info.ID] = &pendingUpload{info: info, done: info.Frame != nil}
+	}
+	return nil
+}
+
+func (u *UploadStore) Create(in FrameInput, length int64) (Upload, error) {
+	if length < 0 {
+		return Upload{}, fmt.Errorf("%w: negative upload length", ErrInvalidPayload)
+	}
+	if length > u.maxBytes {
+		return Upload{}, ErrPayloadTooLarge
+	}
+	if err := ValidateClientSessionID(in.ClientSessionID); err != nil {
+		return Upload{}, err
+	}
+	if err := ValidateDeviceID(in.DeviceID); err != nil {
+		return Upload{}, err
+	}
+	// a timestamp the store would reject fails now, not after the upload
+	if _, _, err := u.frames.parseTimestamp(in.Timestamp); err != nil {
+		return Upload{}, err
+	}
+	var raw [16]byte
+	if _, err := rand.Read(raw[:]); err != nil {
+		return Upload{}, err
+	}
+	now := time.Now().UTC()
+	info := Upload{ID: hex.EncodeToString(raw[:]), FrameID: in.FrameID, Timestamp: in.Timestamp, IdempotencyKey: in.IdempotencyKey, ContentType: in.ContentType, SessionID: in.SessionID, ClientSessionID: in.ClientSessionID, DeviceID: in.DeviceID, Length: length, CreatedAt: now, UpdatedAt: now, ExpiresAt: now.Add(u.expiry)}
+	f, err := os.OpenFile(u.partPath(info.ID), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return Upload{}, fmt.Errorf("create upload: %w", err)
+	}
+	_ = f.Close()
+	if err := u.writeDescriptor(info); err != nil {
+		u.removeFiles(info.ID)
+		return Upload{}, err
+	}
+	u.mu.Lock()
+	u.uploads[info.ID] = &pendingUpload{info: info}
+	u.updateMetricsLocked()
+	u.mu.Unlock()
+	return info, nil
+}
+
+func (u *UploadStore) Get(id string) (Upload, error) {
+	p, err := u.lookup(id)
+	if err != nil {
+		return Upload{}, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.removed {
+		return Upload{}, ErrUploadNotFound
+	}
+	return p.info, nil
+}
+
+// Append writes body at offset, which must equal the current offset. Bytes
+// received before a broken connection are kept, so the client can resume
+// from the offset reported by Get. A PATCH or finalize already in flight,
+// such as one whose connection died without the server noticing yet, makes
+// Append fail with ErrUploadBusy instead of waiting for it.
+func (u *UploadStore) Append(id string, offset int64, body io.Reader) (Upload, error) {
+	p, err := u.lookup(id)
+	if err != nil {
+		return Upload{}, err
+	}
+	if !p.write.TryLock() {
+		return p.snapshot(), ErrUploadBusy
+	}
+	defer p.write.Unlock()
+	p.mu.Lock()
+	info, removed := p.info, p.removed
+	p.mu.Unlock()
+	switch {
+	case removed:
+		return Upload{}, ErrUploadNotFound
+	case info.Frame != nil:
+		return info, ErrUploadCompleted
+	case offset != info.Offset:
+		return info, &UploadOffsetError{Offset: info.Offset}
+	}
+
+	limit := u.maxBytes
+	if info.Length > 0 {
+		limit = info.Length
+	}
+	f, err := os.OpenFile(u.partPath(id), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return info, fmt.Errorf("open upload: %w", err)
+	}
+	src := &errTrackingReader{r: LimitReader(body, limit-offset)}
+	n, err := io.Copy(f, src)
+	if errors.Is(src.err, ErrPayloadTooLarge) {
+		_ = f.Truncate(offset)
+		_ = f.Close()
+		return info, ErrPayloadTooLarge
+	}
+	if serr := f.Sync(); err == nil {
+		err = serr
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	p.mu.Lock()
+	if p.removed {
+		// aborted or expired while the body was streaming
+		p.mu.Unlock()
+		return Upload{}, ErrUploadNotFound
+	}
+	p.info.Offset += n
+	p.info.UpdatedAt = time.Now().UTC()
+	p.info.ExpiresAt = p.info.UpdatedAt.Add(u.expiry)
+	info = p.info
+	p.mu.Unlock()
+	switch {
+	case src.err != nil:
+		return info, fmt.Errorf("%w: %v", ErrInvalidPayload, src.err)
+	case err != nil:
+		return info, fmt.Errorf("write upload: %w", err)
+	}
+	return info, nil
+}
+
+func (p *pendingUpload) snapshot() Upload {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.info
+}
+
+// Finalize saves the received data as a frame through FrameStore.SaveFrame.
+// Finalizing an already finalized upload returns the stored frame marked as
+// a duplicate.
+func (u *UploadStore) Finalize(id string) (FrameMeta, error) {
+	p, err := u.lookup(id)
+	if err != nil {
+		return FrameMeta{}, err
+	}
+	if !p.write.TryLock() {
+		return FrameMeta{}, ErrUploadBusy
+	}
+	defer p.write.Unlock()
+	p.mu.Lock()
+	info, removed := p.info, p.removed
+	p.mu.Unlock()
+	switch {
+	case removed:
+		return FrameMeta{}, ErrUploadNotFound
+	case info.Frame != nil:
+		meta := *info.Frame
+		meta.Duplicate = true
+		return meta, nil
+	case info.Length > 0 && info.Offset != info.Length:
+		return FrameMeta{}, fmt.Errorf("%w: received %d of %d bytes", ErrUploadIncomplete, info.Offset, info.Length)
+	}
+
+	f, err := os.Open(u.partPath(id))
+	if err != nil {
+		return FrameMeta{}, fmt.Errorf("open upload: %w", err)
+	}
+	in := FrameInput{FrameID: info.FrameID, Timestamp: info.Timestamp, IdempotencyKey: info.IdempotencyKey, ContentType: info.ContentType, SessionID: info.SessionID, ClientSessionID: info.ClientSessionID, DeviceID: info.DeviceID}
+	meta, err := u.frames.SaveFrame(in, f)
+	_ = f.Close()
+	if err != nil {
+		return FrameMeta{}, err
+	}
+
+	done := info
+	done.Frame = &meta
+	done.UpdatedAt = time.Now().UTC()
+	done.ExpiresAt = done.UpdatedAt.Add(u.expiry)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.removed {
+		// aborted while the frame was being saved; the frame stays, the
+		// descriptor must not come back
+		return meta, nil
+	}
+	if err := u.writeDescriptor(done); err == nil {
+		p.info = done
+		_ = os.Remove(u.partPath(id))
+		u.mu.Lock()
+		p.done = true
+		u.updateMetricsLocked()
+		u.mu.Unlock()
+	} else {
+		// without the descriptor a retry re-saves the frame, which the
+		// idempotency key (if any) turns into a duplicate
+		p.removed = true
+		u.forget(id)
+	}
+	return meta, nil
+}
+
+func (u *UploadStore) Abort(id string) error {
+	p, err := u.lookup(id)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.removed {
+		return ErrUploadNotFound
+	}
+	p.removed = true
+	u.forget(id)
+	return nil
+}
+
+// Expire removes uploads not touched for the configured expiry.
+func (u *UploadStore) Expire(now time.Time) int {
+	u.mu.Lock()
+	all := make([]*pendingUpload, 0, len(u.uploads))
+	for _, p := range u.uploads {
+		all = append(all, p)
+	}
+	u.mu.Unlock()
+
+	expired := 0
+	for _, p := range all {
+		// uploads busy receiving data are skipped and checked next time
+		if !p.write.TryLock() {
+			continue
+		}
+		p.mu.Lock()
+		if !p.removed && now.After(p.info.ExpiresAt) {
+			p.removed = true
+			u.forget(p.info.ID)
+			if p.info.Frame == nil {
+				expired++
+			}
+		}
+		p.mu.Unlock()
+		p.write.Unlock()
+	}
+	if u.metrics != nil {
+		u.metrics.UploadsExpiredTotal.Add(float64(expired))
+	}
+	return expired
+}
+
+func (u *UploadStore) expireLoop() {
+	ticker := time.NewTicker(1 * time.Minute)
+	defer ticker.Stop()
+	for range ticker.C {
+		u.Expire(time.Now().UTC())
+	}
+}
+
+func (u *UploadStore) lookup(id string) (*pendingUpload, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	p, ok := u.uploads[id]
+	if !ok {
+		return nil, ErrUploadNotFound
+	}
+	return p, nil
+}
+
+func (u *UploadStore) forget(id string) {
+	u.removeFiles(id)
+	u.mu.Lock()
+	delete(u.uploads, id)
+	u.updateMetricsLocked()
+	u.mu.Unlock()
+}
+
+func (u *UploadStore) removeFiles(id string) {
+	_ = os.Remove(u.partPath(id))
+	_ = os.Remove(filepath.Join(u.dir, id+".json"))
+}
+
+func (u *UploadStore) partPath(id string) string {
+	return filepath.Join(u.dir, id+".part")
+}
+
+func (u *UploadStore) writeDescriptor(info Upload) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(u.dir, info.ID+".json.tmp")
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("write upload descriptor: %w", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(u.dir, info.ID+".json")); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("write upload descriptor: %w", err)
+	}
+	return nil
+}
+
+func (u *UploadStore) updateMetricsLocked() {
+	if u.metrics != nil {
+		active := 0
+		for _, p := range u.uploads {
+			if !p.done {
+				active++
+			}
+		}
+		u.metrics.UploadsActive.Set(float64(active))
+	}
+}
@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"ermete/internal/observability"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestUploadResumeAcrossRestartAndFinalize(t *testing.T) {
+	dir := t.TempDir()
+	store := newTestStore(t, dir)
+	payload := testImage(t, "png", "resumable")
+	uploads, err := NewUploadStore(dir, store, 1<<20, time.Hour, observability.NewMetrics(prometheus.NewRegistry()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	up, err := uploads.Create(FrameInput{FrameID: "cam", IdempotencyKey: "k1", ContentType: "image/png"}, int64(len(payload)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	half := len(payload) / 2
+	if _, err := uploads.Append(up.ID, 0, bytes.NewReader(payload[:half])); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := uploads.Finalize(up.ID); !errors.Is(err, ErrUploadIncomplete) {
+		t.Fatalf("expected incomplete upload, got %v", err)
+	}
+
+	reopened, err := NewUploadStore(dir, store, 1<<20, time.Hour, observability.NewMetrics(prometheus.NewRegistry()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := reopened.Get(up.ID)
+	if err != nil || got.Offset != int64(half) || got.Length != int64(len(payload)) {
+		t.Fatalf("unexpected upload after restart: %+v err=%v", got, err)
+	}
+	var mismatch *UploadOffsetError
+	if _, err := reopened.Append(up.ID, 0, bytes.NewReader(payload)); !errors.As(err, &mismatch) || mismatch.Offset != int64(half) {
+		t.Fatalf("expected offset mismatch at %d, got %v", half, err)
+	}
+	if _, err := reopened.Append(up.ID, int64(half), bytes.NewReader(payload[half:])); err != nil {
+		t.Fatal(err)
+	}
+	meta, err := reopened.Finalize(up.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.FrameID != "cam" || meta.Size != int64(len(payload)) || meta.Duplicate {
+		t.Fatalf("unexpected frame: %+v", meta)
+	}
+	again, err := reopened.Finalize(up.ID)
+	if err != nil || !again.Duplicate || again.FileName != meta.FileName {
+		t.Fatalf("expected finalize retry to return the same frame, got %+v err=%v", again, err)
+	}
+	if _, err := reopened.Append(up.ID, int64(len(payload)), bytes.NewReader(nil)); !errors.Is(err, ErrUploadCompleted) {
+		t.Fatalf("expected completed upload, got %v", err)
+	}
+	if _, count := store.LastMeta(); count != 1 {
+		t.Fatalf("expected exactly one stored frame, got %d", count)
+	}
+}
+
+func TestUploadLimitsAndExpiry(t *testing.T) {
+	dir := t.TempDir()
+	store := newTestStore(t, dir)
+	uploads, err := NewUploadStore(dir, store, 8, time.Hour, observability.NewMetrics(prometheus.NewRegistry()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := uploads.Create(FrameInput{}, 9); !errors.Is(err, ErrPayloadTooLarge) {
+		t.Fatalf("expected declared length over the limit to fail, got %v", err)
+	}
+	up, err := uploads.Create(FrameInput{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := uploads.Append(up.ID, 0, bytes.NewReader([]byte("12345"))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := uploads.Append(up.ID, 5, bytes.NewReader([]byte("6789"))); !errors.Is(err, ErrPayloadTooLarge) {
+		t.Fatalf("expected too large, got %v", err)
+	}
+	if got, _ := uploads.Get(up.ID); got.Offset != 5 {
+		t.Fatalf("expected rejected chunk to be discarded, offset %d", got.Offset)
+	}
+
+	if n := uploads.Expire(time.Now().UTC()); n != 0 {
+		t.Fatalf("expected nothing to expire yet, got %d", n)
+	}
+	if n := uploads.Expire(time.Now().UTC().Add(2 * time.Hour)); n != 1 {
+		t.Fatalf("expected one expired upload, got %d", n)
+	}
+	if _, err := uploads.Get(up.ID); !errors.Is(err, ErrUploadNotFound) {
+		t.Fatalf("expected expired upload to be gone, got %v", err)
+	}
+}
+
+func TestUploadGetDuringAppend(t *testing.T) {
+	dir := t.TempDir()
+	uploads, err := NewUploadStore(dir, newTestStore(t, dir), 1<<20, time.Hour, observability.NewMetrics(prometheus.NewRegistry()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	up, err := uploads.Create(FrameInput{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := uploads.Append(up.ID, 0, bytes.NewReader([]byte("12345"))); err != nil {
+		t.Fatal(err)
+	}
+
+	// a client that stalls mid-body
+	pr, pw := io.Pipe()
+	appended := make(chan error, 1)
+	go func() {
+		_, err := uploads.Append(up.ID, 5, pr)
+		appended <- err
+	}()
+	if _, err := pw.Write([]byte("678")); err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(chan Upload, 1)
+	go func() {
+		info, _ := uploads.Get(up.ID)
+		got <- info
+	}()
+	select {
+	case info := <-got:
+		if info.Offset != 5 {
+			t.Fatalf("expected the committed offset 5 during the PATCH, got %d", info.Offset)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Get blocked on the in-flight PATCH")
+	}
+	if _, err := uploads.Append(up.ID, 5, bytes.NewReader([]byte("x"))); !errors.Is(err, ErrUploadBusy) {
+		t.Fatalf("expected a concurrent PATCH to be refused, got %v", err)
+	}
+	if _, err := uploads.Finalize(up.ID); !errors.Is(err, ErrUploadBusy) {
+		t.Fatalf("expected finalize during a PATCH to be refused, got %v", err)
+	}
+
+	_ = pw.Close()
+	if err := <-appended; err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := uploads.Get(up.ID); info.Offset != 8 {
+		t.Fatalf("expected offset 8 after the PATCH, got %d", info.Offset)
+	}
+}
@@ -0,0 +1,501 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ermete/internal/observability"
+
+	"go.uber.org/zap"
+)
+
+const (
+	DefaultMaxAttempts = 8
+	DefaultTimeout     = 10 * time.Second
+	DefaultMinBackoff  = time.Second
+	DefaultMaxBackoff  = 10 * time.Minute
+	DefaultMaxQueue    = 10000
+	DefaultMaxDead     = 10000
+
+	// idleWait bounds how long a worker sleeps with nothing due.
+	idleWait = time.Minute
+)
+
+// Delivery is one event queued for one target URL. Body is the exact JSON
+// that is posted and signed.
+type Delivery struct {
+	ID          string          `json:"id"`
+	URL         string          `json:"url"`
+	Event       string          `json:"event"`
+	Body        json.RawMessage `json:"body"`
+	Attempts    int             `json:"attempts"`
+	NextAttempt time.Time       `json:"next_attempt"`
+	LastError   string          `json:"last_error,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+}
+
+// envelope is the JSON body of every webhook. ID is shared by the
+// deliveries of one event to different targets, so receivers can dedup
+// retries.
+type envelope struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+type Options struct {
+	DataDir     string
+	URLs        []string
+	Secret      string
+	MaxAttempts int
+	Timeout     time.Duration
+	MinBackoff  time.Duration
+	MaxBackoff  time.Duration
+	MaxQueue    int
+	MaxDead     int
+	Client      *http.Client
+	Metrics     *observability.Metrics
+	Logger      *zap.Logger
+}
+
+// Dispatcher posts events to the configured targets from a queue kept under
+// DATA_DIR/webhooks/queue, one <id>.json per pending delivery, so nothing is
+// lost across restarts. Failed attempts are retried with exponential
+// backoff; after MaxAttempts the delivery is moved to
+// DATA_DIR/webhooks/dead, from where it can be moved back to retry it.
+// Every target URL has its own worker, so a receiver that is down or slow
+// only delays its own deliveries. Each target queues at most MaxQueue
+// deliveries, later events for it are dropped; the dead-letter directory
+// keeps the newest MaxDead deliveries.
+type Dispatcher struct {
+	opts     Options
+	queueDir string
+	deadDir  string
+	client   *http.Client
+	logger   *zap.Logger
+
+	mu      sync.Mutex
+	pending map[string]*Delivery
+	queued  map[string]int // pending deliveries per URL
+	targets map[string]*target
+
+	deadMu sync.Mutex
+	dead   int
+
+	// ctx is cancelled by Close, aborting attempts in flight
+	ctx      context.Context
+	cancel   context.CancelFunc
+	stop     chan struct{}
+	wg       sync.WaitGroup
+	stopOnce sync.Once
+}
+
+type target struct {
+	url  string
+	wake chan struct{}
+}
+
+func NewDispatcher(opts Options) (*Dispatcher, error) {
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = DefaultMaxAttempts
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = DefaultMinBackoff
+	}
+	if opts.MaxBackoff < opts.MinBackoff {
+		opts.MaxBackoff = max(DefaultMaxBackoff, opts.MinBackoff)
+	}
+	if opts.MaxQueue <= 0 {
+		opts.MaxQueue = DefaultMaxQueue
+	}
+	if opts.MaxDead <= 0 {
+		opts.MaxDead = DefaultMaxDead
+	}
+	d := &Dispatcher{
+		opts:     opts,
+		queueDir: filepath.Join(opts.DataDir, "webhooks", "queue"),
+		deadDir:  filepath.Join(opts.DataDir, "webhooks", "dead"),
+		client:   opts.Client,
+		logger:   opts.Logger,
+		pending:  map[string]*Delivery{},
+		queued:   map[string]int{},
+		targets:  map[string]*target{},
+		stop:     make(chan struct{}),
+	}
+	d.ctx, d.cancel = context.WithCancel(context.Background())
+	if d.client == nil {
+		d.client = &http.Client{Timeout: opts.Timeout}
+	}
+	if d.logger == nil {
+		d.logger = zap.NewNop()
+	}
+	for _, dir := range []string{d.queueDir, d.deadDir} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("create webhook dir: %w", err)
+		}
+	}
+	if err := d.load(); err != nil {
+		return nil, err
+	}
+	d.updateMetricsLocked()
+	d.pruneDead()
+	// deliveries queued for a URL since removed from the configuration
+	// are still sent
+	urls := append([]string(nil), opts.URLs...)
+	for url := range d.queued {
+		urls = append(urls, url)
+	}
+	for _, url := range urls {
+		if _, ok := d.targets[url]; ok {
+			continue
+		}
+		t := &target{url: url, wake: make(chan struct{}, 1)}
+		d.targets[url] = t
+		d.wg.Add(1)
+		go d.run(t)
+	}
+	return d, nil
+}
+
+func (d *Dispatcher) load() error {
+	matches, err := filepath.Glob(filepath.Join(d.queueDir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, m := range matches {
+		data, err := os.ReadFile(m)
+		if err != nil {
+			return fmt.Errorf("load webhook queue: %w", err)
+		}
+		var dl Delivery
+		if err := json.Unmarshal(data, &dl); err != nil || dl.ID != strings.TrimSuffix(filepath.Base(m), ".json") {
+			d.logger.Warn("dropping unreadable webhook delivery", zap.String("file", m))
+			_ = os.Remove(m)
+			continue
+		}
+		d.pending[dl.ID] = &dl
+		d.queued[dl.URL]++
+	}
+	return nil
+}
+
+// Enqueue persists one delivery of the event per target and wakes their
+// workers. It returns once the deliveries are on disk. A target whose queue
+// already holds MaxQueue deliveries does not get this event.
+func (d *Dispatcher) Enqueue(eventType string, data any) error {
+	now := time.Now().UTC()
+	eventID, err := newID()
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(envelope{ID: eventID, Type: eventType, CreatedAt: now, Data: data})
+	if err != nil {
+		return fmt.Errorf("encode webhook: %w", err)
+	}
+	for _, url := range d.opts.URLs {
+		id, err := newID()
+		if err != nil {
+			return err
+		}
+		d.mu.Lock()
+		full := d.queued[url] >= d.opts.MaxQueue
+		if !full {
+			// reserve the slot so concurrent events cannot overshoot
+			d.queued[url]++
+		}
+		d.mu.Unlock()
+		if full {
+			d.logger.Warn("webhook queue full, dropping event", zap.String("url", url), zap.String("event", eventType))
+			if d.opts.Metrics != nil {
+				d.opts.Metrics.WebhookDroppedTotal.Inc()
+			}
+			continue
+		}
+		dl := &Delivery{ID: id, URL: url, Event: eventType, Body: body, NextAttempt: now, CreatedAt: now}
+		if err := writeDelivery(d.queueDir, dl); err != nil {
+			d.mu.Lock()
+			d.queued[url]--
+			d.mu.Unlock()
+			return err
+		}
+		d.mu.Lock()
+		d.pending[id] = dl
+		d.updateMetricsLocked()
+		d.mu.Unlock()
+		select {
+		case d.targets[url].wake <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Pending returns the queued deliveries, oldest first.
+func (d *Dispatcher) Pending() []Delivery {
+	return d.pendingFor("")
+}
+
+// pendingFor returns the queued deliveries to url, or to every target if
+// url is empty, oldest first.
+func (d *Dispatcher) pendingFor(url string) []Delivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	out := make([]Delivery, 0, len(d.pending))
+	for _, dl := range d.pending {
+		if url == "" || dl.URL == url {
+			out = append(out, *dl)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out
+}
+
+// Close stops the workers. Undelivered events stay queued on disk.
+func (d *Dispatcher) Close() {
+	d.stopOnce.Do(func() {
+		close(d.stop)
+		d.cancel()
+	})
+	d.wg.Wait()
+}
+
+func (d *Dispatcher) run(t *target) {
+	defer d.wg.Done()
+	for {
+		timer := time.NewTimer(d.deliverDue(t.url))
+		select {
+		case <-d.stop:
+			timer.Stop()
+			return
+		case <-t.wake:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// deliverDue attempts every delivery to url whose NextAttempt has passed
+// and returns how long to wait for the next one.
+func (d *Dispatcher) deliverDue(url string) time.Duration {
+	for _, dl := range d.pendingFor(url) {
+		select {
+		case <-d.stop:
+			return idleWait
+		default:
+		}
+		if !dl.NextAttempt.After(time.Now()) {
+			d.attempt(dl)
+		}
+	}
+	wait := idleWait
+	now := time.Now()
+	for _, dl := range d.pendingFor(url) {
+		if w := dl.NextAttempt.Sub(now); w < wait {
+			wait = max(w, 0)
+		}
+	}
+	return wait
+}
+
+func (d *Dispatcher) attempt(dl Delivery) {
+	err := d.post(dl)
+	if err == nil {
+		_ = os.Remove(filepath.Join(d.queueDir, dl.ID+".json"))
+		d.forget(dl.ID)
+		if d.opts.Metrics != nil {
+			d.opts.Metrics.WebhookDeliveredTotal.Inc()
+		}
+		return
+	}
+	if d.ctx.Err() != nil {
+		// interrupted by Close; the attempt does not count
+		return
+	}
+	if d.opts.Metrics != nil {
+		d.opts.Metrics.WebhookFailedAttemptsTotal.Inc()
+	}
+	dl.Attempts++
+	dl.LastError = err.Error()
+	if dl.Attempts >= d.opts.MaxAttempts {
+		d.deadLetter(dl)
+		return
+	}
+	dl.NextAttempt = time.Now().UTC().Add(d.backoff(dl.Attempts))
+	if werr := writeDelivery(d.queueDir, &dl); werr != nil {
+		d.logger.Warn("webhook retry not persisted", zap.String("delivery", dl.ID), zap.Error(werr))
+	}
+	d.mu.Lock()
+	if _, ok := d.pending[dl.ID]; ok {
+		d.pending[dl.ID] = &dl
+	}
+	d.mu.Unlock()
+	d.logger.Debug("webhook delivery failed", zap.String("delivery", dl.ID), zap.String("url", dl.URL), zap.Int("attempts", dl.Attempts), zap.Error(err))
+}
+
+func (d *Dispatcher) deadLetter(dl Delivery) {
+	if err := writeDelivery(d.deadDir, &dl); err != nil {
+		// keep it queued rather than lose it
+		d.logger.Error("webhook dead-letter failed", zap.String("delivery", dl.ID), zap.Error(err))
+		return
+	}
+	_ = os.Remove(filepath.Join(d.queueDir, dl.ID+".json"))
+	d.forget(dl.ID)
+	if d.opts.Metrics != nil {
+		d.opts.Metrics.WebhookDeadLetteredTotal.Inc()
+	}
+	d.logger.Warn("webhook delivery dead-lettered", zap.String("delivery", dl.ID), zap.String("url", dl.URL), zap.String("event", dl.Event), zap.String("error", dl.LastError))
+	d.deadMu.Lock()
+	d.dead++
+	over := d.dead > d.opts.MaxDead
+	if !over && d.opts.Metrics != nil {
+		d.opts.Metrics.WebhookDeadLetterDepth.Set(float64(d.dead))
+	}
+	d.deadMu.Unlock()
+	if over {
+		d.pruneDead()
+	}
+}
+
+// pruneDead counts the dead letters, which an operator may have moved in
+// or out, and removes the oldest beyond MaxDead.
+func (d *Dispatcher) pruneDead() {
+	d.deadMu.Lock()
+	defer d.deadMu.Unlock()
+	entries, err := os.ReadDir(d.deadDir)
+	if err != nil {
+		d.logger.Warn("webhook dead-letter scan failed", zap.Error(err))
+		return
+	}
+	type deadFile struct {
+		name string
+		mod  time.Time
+	}
+	var files []deadFile
+	for _, e := range entries {
+		if e.Type().IsRegular() && strings.HasSuffix(e.Name(), ".json") {
+			if info, err := e.Info(); err == nil {
+				files = append(files, deadFile{e.Name(), info.ModTime()})
+			}
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].mod.Before(files[j].mod) })
+	pruned := 0
+	for len(files)-pruned > d.opts.MaxDead {
+		if err := os.Remove(filepath.Join(d.deadDir, files[pruned].name)); err != nil && !os.IsNotExist(err) {
+			d.logger.Warn("webhook dead-letter prune failed", zap.String("file", files[pruned].name), zap.Error(err))
+			break
+		}
+		pruned++
+	}
+	d.dead = len(files) - pruned
+	if pruned > 0 {
+		d.logger.Warn("webhook dead letters pruned", zap.Int("removed", pruned), zap.Int("max", d.opts.MaxDead))
+	}
+	if d.opts.Metrics != nil {
+		d.opts.Metrics.WebhookDroppedTotal.Add(float64(pruned))
+		d.opts.Metrics.WebhookDeadLetterDepth.Set(float64(d.dead))
+	}
+}
+
+func (d *Dispatcher) post(dl Delivery) error {
+	ctx, cancel := context.WithTimeout(d.ctx, d.opts.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, dl.URL, bytes.NewReader(dl.Body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "ermete-webhook")
+	req.Header.Set("X-Ermete-Event", dl.Event)
+	req.Header.Set("X-Ermete-Delivery", dl.ID)
+	if d.opts.Secret != "" {
+		req.Header.Set("X-Ermete-Signature", Sign(d.opts.Secret, time.Now().Unix(), dl.Body))
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Sign returns the X-Ermete-Signature value for body sent at unix time ts:
+// "t=<ts>,v1=<hex HMAC-SHA256 of "<ts>.<body>">". Receivers should recompute
+// it and reject stale timestamps.
+func Sign(secret string, ts int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(ts, 10) + "."))
+	mac.Write(body)
+	return fmt.Sprintf("t=%d,v1=%s", ts, hex.EncodeToString(mac.Sum(nil)))
+}
+
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	wait := d.opts.MinBackoff
+	for i := 1; i < attempts && wait < d.opts.MaxBackoff; i++ {
+		wait *= 2
+	}
+	return min(wait, d.opts.MaxBackoff)
+}
+
+func (d *Dispatcher) forget(id string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if dl, ok := d.pending[id]; ok {
+		d.queued[dl.URL]--
+		delete(d.pending, id)
+	}
+	d.updateMetricsLocked()
+}
+
+func (d *Dispatcher) updateMetricsLocked() {
+	if d.opts.Metrics != nil {
+		d.opts.Metrics.WebhookQueueDepth.Set(float64(len(d.pending)))
+	}
+}
+
+func writeDelivery(dir string, dl *Delivery) error {
+	data, err := json.Marshal(dl)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, dl.ID+".json.tmp")
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("write webhook delivery: %w", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, dl.ID+".json")); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("write webhook delivery: %w", err)
+	}
+	return nil
+}
+
+func newID() (string, error) {
+	var raw [16]byte
+	if _, err := rand.Read(raw[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(raw[:]), nil
+}
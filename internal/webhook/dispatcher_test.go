@@ -0,0 +1,286 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"ermete/internal/observability"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// standIn records the webhooks it receives after answering the first
+// failures requests with 503.
+type standIn struct {
+	mu       sync.Mutex
+	failures int
+	bodies   [][]byte
+	headers  []http.Header
+	got      chan struct{}
+}
+
+func newStandIn(t *testing.T, failures int) (*standIn, *httptest.Server) {
+	s := &standIn{failures: failures, got: make(chan struct{}, 100)}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		s.mu.Lock()
+		fail := s.failures > 0
+		if fail {
+			s.failures--
+		} else {
+			s.bodies = append(s.bodies, body)
+			s.headers = append(s.headers, r.Header.Clone())
+		}
+		s.mu.Unlock()
+		if fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		s.got <- struct{}{}
+	}))
+	t.Cleanup(srv.Close)
+	return s, srv
+}
+
+func (s *standIn) wait(t *testing.T, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		select {
+		case <-s.got:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for request %d", i+1)
+		}
+	}
+}
+
+func counterValue(t *testing.T, reg *prometheus.Registry, name string) float64 {
+	t.Helper()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range families {
+		if f.GetName() == name {
+			m := f.GetMetric()[0]
+			if m.GetGauge() != nil {
+				return m.GetGauge().GetValue()
+			}
+			return m.GetCounter().GetValue()
+		}
+	}
+	t.Fatalf("metric %s not registered", name)
+	return 0
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestDispatcherSignsAndRetries(t *testing.T) {
+	stand, srv := newStandIn(t, 2)
+	reg := prometheus.NewRegistry()
+	d, err := NewDispatcher(Options{DataDir: t.TempDir(), URLs: []string{srv.URL}, Secret: "s3cret", MinBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond, Metrics: observability.NewMetrics(reg)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	if err := d.Enqueue("frame_saved", map[string]string{"file_name": "a.png"}); err != nil {
+		t.Fatal(err)
+	}
+	stand.wait(t, 3)
+	waitFor(t, func() bool { return len(d.Pending()) == 0 })
+
+	stand.mu.Lock()
+	body, header := stand.bodies[0], stand.headers[0]
+	stand.mu.Unlock()
+	var env struct {
+		ID   string            `json:"id"`
+		Type string            `json:"type"`
+		Data map[string]string `json:"data"`
+	}
+	if err := json.Unmarshal(body, &env); err != nil {
+		t.Fatal(err)
+	}
+	if env.ID == "" || env.Type != "frame_saved" || env.Data["file_name"] != "a.png" || header.Get("X-Ermete-Event") != "frame_saved" {
+		t.Fatalf("unexpected webhook %s %v", body, header)
+	}
+	sig := header.Get("X-Ermete-Signature")
+	ts, err := strconv.ParseInt(strings.TrimPrefix(strings.Split(sig, ",")[0], "t="), 10, 64)
+	if err != nil || sig != Sign("s3cret", ts, body) {
+		t.Fatalf("signature %q does not verify", sig)
+	}
+	if got := counterValue(t, reg, "ermete_webhook_failed_attempts_total"); got != 2 {
+		t.Fatalf("expected 2 failed attempts, got %v", got)
+	}
+	if got := counterValue(t, reg, "ermete_webhook_delivered_total"); got != 1 {
+		t.Fatalf("expected 1 delivery, got %v", got)
+	}
+}
+
+func TestDispatcherDeadLetters(t *testing.T) {
+	stand, srv := newStandIn(t, 100)
+	dir := t.TempDir()
+	reg := prometheus.NewRegistry()
+	d, err := NewDispatcher(Options{DataDir: dir, URLs: []string{srv.URL}, MaxAttempts: 3, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond, Metrics: observability.NewMetrics(reg)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if err := d.Enqueue("session_acquired", map[string]string{"session_id": "s"}); err != nil {
+		t.Fatal(err)
+	}
+	stand.wait(t, 3)
+	waitFor(t, func() bool { return len(d.Pending()) == 0 })
+
+	dead, _ := filepath.Glob(filepath.Join(dir, "webhooks", "dead", "*.json"))
+	queued, _ := filepath.Glob(filepath.Join(dir, "webhooks", "queue", "*.json"))
+	if len(dead) != 1 || len(queued) != 0 {
+		t.Fatalf("expected one dead letter and an empty queue, got %v %v", dead, queued)
+	}
+	data, err := os.ReadFile(dead[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dl Delivery
+	if err := json.Unmarshal(data, &dl); err != nil || dl.Attempts != 3 || !strings.Contains(dl.LastError, "503") {
+		t.Fatalf("unexpected dead letter %s err=%v", data, err)
+	}
+	if got := counterValue(t, reg, "ermete_webhook_dead_lettered_total"); got != 1 {
+		t.Fatalf("expected 1 dead letter, got %v", got)
+	}
+	if got := counterValue(t, reg, "ermete_webhook_queue_depth"); got != 0 {
+		t.Fatalf("expected empty queue gauge, got %v", got)
+	}
+}
+
+func TestDispatcherQueueSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	// nothing listens here, so the delivery stays queued
+	d, err := NewDispatcher(Options{DataDir: dir, URLs: []string{"http://127.0.0.1:1"}, MinBackoff: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Enqueue("session_released", map[string]string{"session_id": "s"}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { p := d.Pending(); return len(p) == 1 && p[0].Attempts == 1 })
+	d.Close()
+
+	stand, srv := newStandIn(t, 0)
+	queued, _ := filepath.Glob(filepath.Join(dir, "webhooks", "queue", "*.json"))
+	if len(queued) != 1 {
+		t.Fatalf("expected the delivery on disk, got %v", queued)
+	}
+	// point the persisted delivery at the stand-in and make it due
+	var dl Delivery
+	data, _ := os.ReadFile(queued[0])
+	if err := json.Unmarshal(data, &dl); err != nil {
+		t.Fatal(err)
+	}
+	dl.URL, dl.NextAttempt = srv.URL, time.Now()
+	if err := writeDelivery(filepath.Dir(queued[0]), &dl); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := NewDispatcher(Options{DataDir: dir, URLs: []string{srv.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	stand.wait(t, 1)
+	waitFor(t, func() bool { return len(reopened.Pending()) == 0 })
+	stand.mu.Lock()
+	defer stand.mu.Unlock()
+	if !strings.Contains(string(stand.bodies[0]), `"session_released"`) {
+		t.Fatalf("unexpected body %s", stand.bodies[0])
+	}
+}
+
+func TestDispatcherTargetsAreIndependent(t *testing.T) {
+	// a receiver that never answers within the attempt timeout
+	release := make(chan struct{})
+	hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	t.Cleanup(hung.Close)
+	t.Cleanup(func() { close(release) })
+	stand, srv := newStandIn(t, 0)
+
+	d, err := NewDispatcher(Options{DataDir: t.TempDir(), URLs: []string{hung.URL, srv.URL}, Timeout: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	for i := 0; i < 3; i++ {
+		if err := d.Enqueue("frame_saved", map[string]int{"n": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stand.wait(t, 3)
+	if n := len(d.pendingFor(hung.URL)); n != 3 {
+		t.Fatalf("expected the hung target to keep its 3 deliveries, got %d", n)
+	}
+}
+
+func TestDispatcherCaps(t *testing.T) {
+	stand, srv := newStandIn(t, 100)
+	dir := t.TempDir()
+	reg := prometheus.NewRegistry()
+	d, err := NewDispatcher(Options{DataDir: dir, URLs: []string{srv.URL}, MaxAttempts: 1, MinBackoff: time.Hour, MaxQueue: 2, MaxDead: 2, Metrics: observability.NewMetrics(reg)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	for i := 0; i < 3; i++ {
+		if err := d.Enqueue("frame_saved", map[string]int{"n": i}); err != nil {
+			t.Fatal(err)
+		}
+		stand.wait(t, 1)
+		waitFor(t, func() bool { return len(d.Pending()) == 0 })
+	}
+	if got := counterValue(t, reg, "ermete_webhook_dead_letter_depth"); got != 2 {
+		t.Fatalf("expected the dead-letter directory capped at 2, got %v", got)
+	}
+	dead, _ := filepath.Glob(filepath.Join(dir, "webhooks", "dead", "*.json"))
+	if len(dead) != 2 {
+		t.Fatalf("expected 2 dead letters on disk, got %v", dead)
+	}
+
+	// nothing listens here, so deliveries pile up
+	reg2 := prometheus.NewRegistry()
+	d2, err := NewDispatcher(Options{DataDir: t.TempDir(), URLs: []string{"http://127.0.0.1:1"}, MinBackoff: time.Hour, MaxQueue: 2, Metrics: observability.NewMetrics(reg2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d2.Close()
+	for i := 0; i < 3; i++ {
+		if err := d2.Enqueue("frame_saved", map[string]int{"n": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(d2.Pending()); n != 2 {
+		t.Fatalf("expected the queue capped at 2, got %d", n)
+	}
+	if got := counterValue(t, reg, "ermete_webhook_dropped_total"); got != 1 {
+		t.Fatalf("expected 1 pruned dead letter, got %v", got)
+	}
+	if got := counterValue(t, reg2, "ermete_webhook_dropped_total"); got != 1 {
+		t.Fatalf("expected 1 dropped event, got %v", got)
+	}
+}
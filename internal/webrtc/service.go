@@ -7,6 +7,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
@@ -34,6 +35,10 @@ type CommandEnvelope struct {
 	Bin  string `json:"bin,omitempty"`
 }
 
+// eventQueueSize bounds the frame events waiting for a peer's cmd channel;
+// events arriving while it is full are dropped.
+const eventQueueSize = 32
+
 type Service struct {
 	cfg      config.Config
 	logger   *zap.Logger
@@ -43,6 +48,9 @@ type Service struct {
 	api      *pion.API
 	upgrader websocket.Upgrader
 	started  time.Time
+
+	mu   sync.Mutex
+	peer *PeerSession
 }
 
 func NewService(cfg config.Config, logger *zap.Logger, metrics *observability.Metrics, sessions *session.Manager, store *storage.FrameStore) (*Service, error) {
@@ -53,7 +61,7 @@ func NewService(cfg config.Config, logger *zap.Logger, metrics *observability.Me
 	se := pion.SettingEngine{}
 	se.SetIncludeLoopbackCandidate(true)
 	api := pion.NewAPI(pion.WithMediaEngine(m), pion.WithSettingEngine(se))
-	return &Service{
+	svc := &Service{
 		cfg:      cfg,
 		logger:   logger,
 		metrics:  metrics,
@@ -62,7 +70,11 @@ func NewService(cfg config.Config, logger *zap.Logger, metrics *observability.Me
 		api:      api,
 		upgrader: websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }},
 		started:  time.Now().UTC(),
-	}, nil
+	}
+	if store != nil {
+		store.Subscribe(svc.handleStoreEvent)
+	}
+	return svc, nil
 }
 
 type PeerSession struct {
@@ -73,8 +85,22 @@ type PeerSession struct {
 	cmdChannel *pion.DataChannel
 	logger     *zap.Logger
 	svc        *Service
+	events     chan CommandEnvelope
+	done       chan struct{}
 	mu         sync.Mutex
 	closed     bool
+	topics     map[string]bool
+}
+
+func newPeerSession(s *Service, conn *websocket.Conn) *PeerSession {
+	return &PeerSession{
+		id:     fmt.Sprintf("sess-%d", time.Now().UnixNano()),
+		conn:   conn,
+		logger: s.logger,
+		svc:    s,
+		events: make(chan CommandEnvelope, eventQueueSize),
+		done:   make(chan struct{}),
+	}
 }
 
 func (p *PeerSession) ID() string { return p.id }
@@ -87,6 +113,8 @@ func (p *PeerSession) Close(reason string) {
 	}
 	p.closed = true
 	p.mu.Unlock()
+	close(p.done)
+	p.svc.setPeer(p, nil)
 	_ = p.sendSignal(SignalMessage{Type: "error", Message: reason})
 	_ = p.sendSignal(SignalMessage{Type: "bye"})
 	if p.pc != nil {
@@ -98,14 +126,16 @@ func (p *PeerSession) Close(reason string) {
 
 func (s *Service) HandleWS(ctx context.Context, wsc *websocket.Conn) {
 	s.metrics.WSConnectionsTotal.Inc()
-	peer := &PeerSession{id: fmt.Sprintf("sess-%d", time.Now().UnixNano()), conn: wsc, logger: s.logger, svc: s}
+	peer := newPeerSession(s, wsc)
 	if err := s.sessions.Acquire(peer); err != nil {
 		s.metrics.WSRejectTotal.Inc()
 		_ = writeJSON(wsc, SignalMessage{Type: "error", Message: "session already active"})
 		_ = wsc.Close()
 		return
 	}
+	s.setPeer(nil, peer)
 	defer peer.Close("session_ended")
+	go peer.deliverEvents()
 
 	if err := s.initPeer(peer); err != nil {
 		peer.logger.Error("init peer failed", zap.Error(err))
@@ -191,7 +221,9 @@ func (s *Service) initPeer(ps *PeerSession) error {
 		if dc.Label() != "cmd" {
 			return
 		}
+		ps.mu.Lock()
 		ps.cmdChannel = dc
+		ps.mu.Unlock()
 		dc.OnMessage(func(msg pion.DataChannelMessage) {
 			s.handleCommand(ps, msg)
 		})
@@ -255,11 +287,109 @@ func (s *Service) handleCommand(ps *PeerSession, msg pion.DataChannelMessage) {
 		_ = ps.sendCmd(CommandEnvelope{Type: "server_status", Text: string(b)})
 	case "say":
 		_ = ps.sendCmd(CommandEnvelope{Type: "say", Text: "audio loopback active"})
+	case "subscribe", "unsubscribe":
+		topics, err := parseTopics(env.Text)
+		if err != nil {
+			_ = ps.sendCmd(CommandEnvelope{Type: "error", Text: err.Error()})
+			return
+		}
+		_ = ps.sendCmd(CommandEnvelope{Type: "subscribed", Text: ps.setTopics(topics, env.Type == "subscribe")})
 	default:
 		_ = ps.sendCmd(CommandEnvelope{Type: "error", Text: "unknown command"})
 	}
 }
 
+// parseTopics reads the comma-separated event types of a subscribe command;
+// an empty list means frame_saved.
+func parseTopics(text string) ([]string, error) {
+	if strings.TrimSpace(text) == "" {
+		return []string{storage.EventFrameSaved}, nil
+	}
+	var topics []string
+	for _, t := range strings.Split(text, ",") {
+		t = strings.TrimSpace(t)
+		switch t {
+		case storage.EventFrameSaved, storage.EventMotion:
+			topics = append(topics, t)
+		default:
+			return nil, fmt.Errorf("unknown event type %q", t)
+		}
+	}
+	return topics, nil
+}
+
+// setTopics adds or removes topics and returns the resulting subscription
+// as a comma-separated list.
+func (p *PeerSession) setTopics(topics []string, on bool) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.topics == nil {
+		p.topics = map[string]bool{}
+	}
+	for _, t := range topics {
+		if on {
+			p.topics[t] = true
+		} else {
+			delete(p.topics, t)
+		}
+	}
+	out := make([]string, 0, len(p.topics))
+	for _, t := range []string{storage.EventFrameSaved, storage.EventMotion} {
+		if p.topics[t] {
+			out = append(out, t)
+		}
+	}
+	return strings.Join(out, ",")
+}
+
+func (p *PeerSession) subscribed(topic string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.topics[topic]
+}
+
+// setPeer replaces old with next as the peer that receives store events;
+// a nil old replaces any peer.
+func (s *Service) setPeer(old, next *PeerSession) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if old == nil || s.peer == old {
+		s.peer = next
+	}
+}
+
+// handleStoreEvent queues frame events for the connected peer if it opted
+// in. It runs inside SaveFrame, so it never waits on the peer: when the
+// queue is full the event is dropped.
+func (s *Service) handleStoreEvent(ev storage.Event) {
+	s.mu.Lock()
+	peer := s.peer
+	s.mu.Unlock()
+	if peer == nil || !peer.subscribed(ev.Type) {
+		return
+	}
+	b, _ := json.Marshal(ev.Frame)
+	select {
+	case peer.events <- CommandEnvelope{Type: ev.Type, Text: string(b)}:
+	default:
+		s.metrics.DCEventsDroppedTotal.Inc()
+	}
+}
+
+// deliverEvents writes queued events to the cmd channel until the peer closes.
+func (p *PeerSession) deliverEvents() {
+	for {
+		select {
+		case <-p.done:
+			return
+		case env := <-p.events:
+			if err := p.sendCmd(env); err != nil {
+				p.logger.Debug("event delivery failed", zap.String("type", env.Type), zap.Error(err))
+			}
+		}
+	}
+}
+
 func (s *Service) iceServers() []pion.ICEServer {
 	out := make([]pion.ICEServer, 0, 2)
 	if len(s.cfg.WebRTCStunURLs) > 0 {
@@ -274,11 +404,14 @@ func (s *Service) iceServers() []pion.ICEServer {
 func (p *PeerSession) sendSignal(msg SignalMessage) error { return writeJSON(p.conn, msg) }
 
 func (p *PeerSession) sendCmd(msg CommandEnvelope) error {
-	if p.cmdChannel == nil {
+	p.mu.Lock()
+	dc := p.cmdChannel
+	p.mu.Unlock()
+	if dc == nil {
 		return nil
 	}
 	b, _ := json.Marshal(msg)
-	return p.cmdChannel.SendText(string(b))
+	return dc.SendText(string(b))
 }
 
 func writeJSON(conn *websocket.Conn, v any) error {
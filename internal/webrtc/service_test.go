@@ -0,0 +1,102 @@
+package webrtc
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+	"time"
+
+	"ermete/internal/config"
+	"ermete/internal/observability"
+	"ermete/internal/session"
+	"ermete/internal/storage"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+func testPNG(t *testing.T, seed int) *bytes.Reader {
+	t.Helper()
+	img := image.NewGray(image.Rect(0, 0, 8, 8))
+	img.SetGray(seed%8, seed/8%8, color.Gray{Y: uint8(seed)})
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return bytes.NewReader(buf.Bytes())
+}
+
+func TestFrameEventsAreOptInAndNeverBlock(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metrics := observability.NewMetrics(reg)
+	store, err := storage.NewFrameStore(t.TempDir(), 10*time.Minute, 100, metrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	svc, err := NewService(config.Config{}, zap.NewNop(), metrics, session.NewManager(config.SessionPolicyRejectSecond), store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// no delivery goroutine: the queue only fills up
+	peer := newPeerSession(svc, nil)
+	svc.setPeer(nil, peer)
+
+	if _, err := store.SaveFrame(storage.FrameInput{FrameID: "quiet"}, testPNG(t, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if len(peer.events) != 0 {
+		t.Fatalf("expected no events before subscribing, got %d", len(peer.events))
+	}
+
+	topics, err := parseTopics("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := peer.setTopics(topics, true); got != storage.EventFrameSaved {
+		t.Fatalf("unexpected subscription %q", got)
+	}
+	saved, err := store.SaveFrame(storage.FrameInput{FrameID: "f"}, testPNG(t, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := <-peer.events
+	var meta storage.FrameMeta
+	if err := json.Unmarshal([]byte(env.Text), &meta); err != nil {
+		t.Fatal(err)
+	}
+	if env.Type != storage.EventFrameSaved || meta.FileName != saved.FileName {
+		t.Fatalf("unexpected envelope %+v", env)
+	}
+
+	for i := 0; i < eventQueueSize+3; i++ {
+		if _, err := store.SaveFrame(storage.FrameInput{FrameID: "f"}, testPNG(t, i+2)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(peer.events) != eventQueueSize {
+		t.Fatalf("expected a full queue, got %d", len(peer.events))
+	}
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range families {
+		if f.GetName() == "ermete_dc_events_dropped_total" && f.GetMetric()[0].GetCounter().GetValue() != 3 {
+			t.Fatalf("expected 3 dropped events, got %v", f.GetMetric()[0].GetCounter().GetValue())
+		}
+	}
+}
+
+func TestParseTopics(t *testing.T) {
+	topics, err := parseTopics(" frame_saved, motion ")
+	if err != nil || len(topics) != 2 {
+		t.Fatalf("unexpected topics %v err=%v", topics, err)
+	}
+	if _, err := parseTopics("frame_saved,bogus"); err == nil {
+		t.Fatal("expected unknown event type to be rejected")
+	}
+}